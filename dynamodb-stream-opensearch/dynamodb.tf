@@ -16,6 +16,11 @@ resource "aws_dynamodb_table" "main" {
     type = "S"
   }
 
+  ttl {
+    attribute_name = "expiresAt"
+    enabled        = true
+  }
+
   tags = {
     Application = "${var.resource_prefix}"
   }
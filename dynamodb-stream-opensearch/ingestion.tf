@@ -71,7 +71,10 @@ resource "aws_iam_role_policy" "lambda_csv_ingestion_policy" {
 # CSV Ingestion Lambda Build and Packaging
 resource "null_resource" "lambda_csv_ingestion_build" {
   triggers = {
-    source_hash = filemd5("${path.module}/src/csv-ingestion/main.go")
+    source_hash = sha1(join("", [
+      filemd5("${path.module}/src/csv-ingestion/main.go"),
+      filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+    ]))
   }
 
   provisioner "local-exec" {
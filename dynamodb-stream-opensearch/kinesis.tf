@@ -0,0 +1,75 @@
+# Kinesis Data Streams for DynamoDB, an alternative to DynamoDB Streams with
+# a longer retention and more consumers, enabled with stream_source = "kinesis"
+resource "aws_kinesis_stream" "changes" {
+  count = var.stream_source == "kinesis" ? 1 : 0
+
+  name             = "${var.resource_prefix}-changes"
+  retention_period = var.kinesis_retention_hours
+
+  stream_mode_details {
+    stream_mode = "ON_DEMAND"
+  }
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+resource "aws_dynamodb_kinesis_streaming_destination" "changes" {
+  count = var.stream_source == "kinesis" ? 1 : 0
+
+  stream_arn                               = aws_kinesis_stream.changes[0].arn
+  table_name                               = aws_dynamodb_table.main.name
+  approximate_creation_date_time_precision = "MILLISECOND"
+}
+
+# Stream Processor IAM Policy for the Kinesis stream
+resource "aws_iam_role_policy" "lambda_stream_processor_kinesis_policy" {
+  count = var.stream_source == "kinesis" ? 1 : 0
+
+  name = "${var.resource_prefix}-stream-processor-kinesis-policy"
+  role = aws_iam_role.lambda_stream_processor_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect = "Allow"
+        Action = [
+          "kinesis:DescribeStream",
+          "kinesis:DescribeStreamSummary",
+          "kinesis:GetRecords",
+          "kinesis:GetShardIterator",
+          "kinesis:ListShards",
+          "kinesis:ListStreams"
+        ]
+        Resource = aws_kinesis_stream.changes[0].arn
+      }
+    ]
+  })
+}
+
+# Kinesis Stream -> Stream Processor Lambda
+resource "aws_lambda_event_source_mapping" "kinesis_stream" {
+  count = var.stream_source == "kinesis" ? 1 : 0
+
+  event_source_arn  = aws_kinesis_stream.changes[0].arn
+  function_name     = aws_lambda_function.lambda_stream_processor.arn
+  starting_position = "LATEST"
+  batch_size        = 100
+
+  maximum_retry_attempts         = var.stream_maximum_retry_attempts
+  bisect_batch_on_function_error = true
+
+  destination_config {
+    on_failure {
+      destination_arn = aws_sqs_queue.stream_failures.arn
+    }
+  }
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+
+  depends_on = [aws_iam_role_policy.lambda_stream_processor_kinesis_policy, aws_iam_role_policy.lambda_stream_processor_failures_policy]
+}
@@ -35,7 +35,11 @@ resource "aws_opensearch_domain" "main" {
       {
         Effect = "Allow"
         Principal = {
-          AWS = aws_iam_role.lambda_stream_processor_role.arn
+          AWS = [
+            aws_iam_role.lambda_stream_processor_role.arn,
+            aws_iam_role.lambda_search_api_role.arn,
+            aws_iam_role.lambda_reconcile_role.arn
+          ]
         }
         Action   = "es:*"
         Resource = "arn:aws:es:${var.aws_region}:${data.aws_caller_identity.current.account_id}:domain/${var.resource_prefix}/*"
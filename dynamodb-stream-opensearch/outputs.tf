@@ -21,3 +21,15 @@ output "opensearch_endpoint" {
 output "opensearch_dashboard_endpoint" {
   value = aws_opensearch_domain.main.dashboard_endpoint
 }
+
+output "search_api_url" {
+  value = aws_lambda_function_url.search_api.function_url
+}
+
+output "reconcile_function_name" {
+  value = aws_lambda_function.lambda_reconcile.function_name
+}
+
+output "stream_failures_queue_url" {
+  value = aws_sqs_queue.stream_failures.url
+}
@@ -0,0 +1,126 @@
+# Reconciliation Job IAM Role
+resource "aws_iam_role" "lambda_reconcile_role" {
+  name = "${var.resource_prefix}-reconcile-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "lambda.amazonaws.com"
+        }
+      }
+    ]
+  })
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+# Reconciliation Job IAM Policy
+resource "aws_iam_role_policy" "lambda_reconcile_policy" {
+  name = "${var.resource_prefix}-reconcile-policy"
+  role = aws_iam_role.lambda_reconcile_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "dynamodb:Scan"
+        Resource = aws_dynamodb_table.main.arn
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "es:ESHttpPost",
+          "es:ESHttpPut",
+          "es:ESHttpDelete",
+          "es:ESHttpGet"
+        ]
+        Resource = "${aws_opensearch_domain.main.arn}/*"
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ]
+        Resource = "arn:aws:logs:*:*:*"
+      }
+    ]
+  })
+}
+
+# Reconciliation Job IAM Policy for OpenSearch basic auth credentials
+resource "aws_iam_role_policy" "lambda_reconcile_secret_policy" {
+  count = var.opensearch_credentials_secret_arn == "" ? 0 : 1
+
+  name = "${var.resource_prefix}-reconcile-secret-policy"
+  role = aws_iam_role.lambda_reconcile_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "secretsmanager:GetSecretValue"
+        Resource = var.opensearch_credentials_secret_arn
+      }
+    ]
+  })
+}
+
+# Reconciliation Job Lambda Function, the stream processor binary in RECONCILE mode
+resource "aws_lambda_function" "lambda_reconcile" {
+  filename      = "${path.module}/dist/stream-to-opensearch/bootstrap.zip"
+  function_name = "${var.resource_prefix}-reconcile"
+  role          = aws_iam_role.lambda_reconcile_role.arn
+  handler       = "bootstrap"
+  runtime       = "provided.al2023"
+  memory_size   = 1024
+  timeout       = 900
+
+  environment {
+    variables = merge(local.stream_processor_environment, {
+      MODE              = "RECONCILE"
+      RECONCILE_INDICES = var.search_api_indices
+      CHANGE_PUBLISHER  = ""
+    })
+  }
+
+  depends_on = [null_resource.lambda_stream_processor_build]
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+# Scheduled reconciliation, disabled when reconcile_schedule is empty
+resource "aws_cloudwatch_event_rule" "reconcile_schedule" {
+  count = var.reconcile_schedule == "" ? 0 : 1
+
+  name                = "${var.resource_prefix}-reconcile-schedule"
+  schedule_expression = var.reconcile_schedule
+}
+
+resource "aws_cloudwatch_event_target" "reconcile_schedule" {
+  count = var.reconcile_schedule == "" ? 0 : 1
+
+  rule  = aws_cloudwatch_event_rule.reconcile_schedule[0].name
+  arn   = aws_lambda_function.lambda_reconcile.arn
+  input = jsonencode({ repair = var.reconcile_repair })
+}
+
+resource "aws_lambda_permission" "allow_events_invoke_reconcile" {
+  count = var.reconcile_schedule == "" ? 0 : 1
+
+  statement_id  = "AllowExecutionFromEventBridge"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.lambda_reconcile.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.reconcile_schedule[0].arn
+}
@@ -0,0 +1,126 @@
+# Search API Lambda IAM Role
+resource "aws_iam_role" "lambda_search_api_role" {
+  name = "${var.resource_prefix}-search-api-role"
+
+  assume_role_policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Action = "sts:AssumeRole"
+        Effect = "Allow"
+        Principal = {
+          Service = "lambda.amazonaws.com"
+        }
+      }
+    ]
+  })
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+# Search API Lambda IAM Policy
+resource "aws_iam_role_policy" "lambda_search_api_policy" {
+  name = "${var.resource_prefix}-search-api-policy"
+  role = aws_iam_role.lambda_search_api_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect = "Allow"
+        Action = [
+          "es:ESHttpGet",
+          "es:ESHttpPost"
+        ]
+        Resource = "${aws_opensearch_domain.main.arn}/*"
+      },
+      {
+        Effect   = "Allow"
+        Action   = "dynamodb:BatchGetItem"
+        Resource = aws_dynamodb_table.main.arn
+      },
+      {
+        Effect = "Allow"
+        Action = [
+          "logs:CreateLogGroup",
+          "logs:CreateLogStream",
+          "logs:PutLogEvents"
+        ]
+        Resource = "arn:aws:logs:*:*:*"
+      }
+    ]
+  })
+}
+
+# Search API IAM Policy for OpenSearch basic auth credentials
+resource "aws_iam_role_policy" "lambda_search_api_secret_policy" {
+  count = var.opensearch_credentials_secret_arn == "" ? 0 : 1
+
+  name = "${var.resource_prefix}-search-api-secret-policy"
+  role = aws_iam_role.lambda_search_api_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "secretsmanager:GetSecretValue"
+        Resource = var.opensearch_credentials_secret_arn
+      }
+    ]
+  })
+}
+
+# Search API Build and Packaging
+resource "null_resource" "lambda_search_api_build" {
+  triggers = {
+    source_hash = sha1(join("", concat(
+      [for f in sort(setunion(fileset("${path.module}/src", "search-api/*.go"), fileset("${path.module}/src", "search/*.go"))) : filemd5("${path.module}/src/${f}")],
+      [filemd5("${path.module}/../shared/lambdalog/lambdalog.go")],
+    )))
+  }
+
+  provisioner "local-exec" {
+    command = <<EOT
+      cd ${path.module}/src/search-api
+      go mod tidy
+      GOOS=linux GOARCH=amd64 go build -o ../../dist/search-api/bootstrap .
+      cd ../../dist/search-api
+      zip bootstrap.zip bootstrap
+    EOT
+  }
+}
+
+# Search API Lambda Function
+resource "aws_lambda_function" "lambda_search_api" {
+  filename      = "${path.module}/dist/search-api/bootstrap.zip"
+  function_name = "${var.resource_prefix}-search-api"
+  role          = aws_iam_role.lambda_search_api_role.arn
+  handler       = "bootstrap"
+  runtime       = "provided.al2023"
+  memory_size   = 256
+  timeout       = 10
+
+  environment {
+    variables = {
+      TABLE_NAME                    = aws_dynamodb_table.main.name
+      OPENSEARCH_ENDPOINT           = aws_opensearch_domain.main.endpoint
+      OPENSEARCH_INDEX              = var.search_api_indices
+      OPENSEARCH_SIGNING            = var.opensearch_signing
+      OPENSEARCH_CREDENTIALS_SECRET = var.opensearch_credentials_secret_arn
+    }
+  }
+
+  depends_on = [null_resource.lambda_search_api_build]
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+# Search API Function URL, callers sign their requests with SigV4
+resource "aws_lambda_function_url" "search_api" {
+  function_name      = aws_lambda_function.lambda_search_api.function_name
+  authorization_type = "AWS_IAM"
+}
@@ -2,7 +2,10 @@ module csv-ingestion
 
 go 1.25
 
+replace aws-labs-lambdalog => ../../../shared/lambdalog
+
 require (
+	aws-labs-lambdalog v0.0.0-00010101000000-000000000000
 	github.com/aws/aws-lambda-go v1.46.0
 	github.com/aws/aws-sdk-go-v2 v1.30.4
 	github.com/aws/aws-sdk-go-v2/config v1.27.7
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/csv"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
+	lambdalog "aws-labs-lambdalog"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -28,9 +30,11 @@ const pkColumn = "pk"
 const skColumn = "sk"
 
 func init() {
+	lambdalog.Setup()
+
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
-		log.Fatalf("Unable to load SDK config: %v", err)
+		lambdalog.Fatal("Unable to load SDK config", "error", err)
 	}
 
 	s3Client = s3.NewFromConfig(cfg)
@@ -38,7 +42,7 @@ func init() {
 	tableName = os.Getenv("TABLE_NAME")
 
 	if tableName == "" {
-		log.Fatal("TABLE_NAME environment variable is required")
+		lambdalog.Fatal("TABLE_NAME environment variable is required")
 	}
 }
 
@@ -46,8 +50,9 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 	for _, record := range s3Event.Records {
 		bucket := record.S3.Bucket.Name
 		key := record.S3.Object.Key
+		ctx := lambdalog.With(ctx, lambdalog.KeyS3Key, key)
 
-		log.Printf("Processing file: s3://%s/%s", bucket, key)
+		slog.InfoContext(ctx, "Processing file", "bucket", bucket)
 
 		// Get the CSV file from S3
 		obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
@@ -55,7 +60,7 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 			Key:    aws.String(key),
 		})
 		if err != nil {
-			log.Printf("Failed to get object: %v", err)
+			slog.ErrorContext(ctx, "Failed to get object", "error", err)
 			return fmt.Errorf("failed to get object from S3: %w", err)
 		}
 		defer obj.Body.Close()
@@ -65,10 +70,10 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 		reader.Comma = separator
 		headers, err := reader.Read()
 		if err != nil {
-			log.Printf("Failed to read CSV header: %v", err)
+			slog.ErrorContext(ctx, "Failed to read CSV header", "error", err)
 			return fmt.Errorf("failed to read CSV header: %w", err)
 		}
-		log.Printf("CSV Headers: %v", headers)
+		slog.InfoContext(ctx, "CSV headers", "headers", headers)
 
 		// Validate required columns
 		pkExists := false
@@ -113,7 +118,7 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 				if err.Error() == "EOF" {
 					break
 				}
-				log.Printf("Error reading CSV row %d: %v", rowNum, err)
+				slog.WarnContext(ctx, "Error reading CSV row", "row", rowNum, "error", err)
 				continue
 			}
 
@@ -128,7 +133,7 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 			// Marshal to DynamoDB format
 			av, err := attributevalue.MarshalMap(item)
 			if err != nil {
-				log.Printf("Failed to marshal item at row %d: %v", rowNum, err)
+				slog.WarnContext(ctx, "Failed to marshal item", "row", rowNum, "error", err)
 				continue
 			}
 
@@ -142,7 +147,7 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 			// Flush batch when full
 			if len(batch) >= batchSize {
 				if err := flushBatch(); err != nil {
-					log.Printf("Error flushing batch: %v", err)
+					slog.ErrorContext(ctx, "Error flushing batch", "error", err)
 					return err
 				}
 			}
@@ -152,11 +157,11 @@ func handler(ctx context.Context, s3Event events.S3Event) error {
 
 		// Flush remaining items
 		if err := flushBatch(); err != nil {
-			log.Printf("Error flushing final batch: %v", err)
+			slog.ErrorContext(ctx, "Error flushing final batch", "error", err)
 			return err
 		}
 
-		log.Printf("Successfully processed %d rows from %s", rowNum, key)
+		slog.InfoContext(ctx, "Successfully processed file", "rows", rowNum)
 	}
 
 	return nil
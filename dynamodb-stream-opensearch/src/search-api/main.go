@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	lambdalog "aws-labs-lambdalog"
+	search "dynamodb-stream-opensearch-search"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
+)
+
+var (
+	opensearchEndpoint string
+	opensearchIndices  []string
+	tableName          string
+	osClient           *opensearchapi.Client
+	dynamodbClient     *dynamodb.Client
+)
+
+func init() {
+	lambdalog.Setup()
+
+	opensearchEndpoint = os.Getenv("OPENSEARCH_ENDPOINT")
+	// Comma separated indices or patterns, e.g. "dynamodb-items,users,events-*"
+	opensearchIndices = strings.Split(os.Getenv("OPENSEARCH_INDEX"), ",")
+	tableName = os.Getenv("TABLE_NAME")
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		lambdalog.Fatal("Unable to load SDK config", "error", err)
+	}
+	dynamodbClient = dynamodb.NewFromConfig(cfg)
+}
+
+// SearchResult is the response body of the search API
+type SearchResult struct {
+	Total  int                      `json:"total"`
+	Hits   []Hit                    `json:"hits"`
+	Facets map[string][]FacetBucket `json:"facets,omitempty"`
+	// Next is the cursor of the following page, passed back as after
+	Next string `json:"next,omitempty"`
+}
+
+type Hit struct {
+	Index     string              `json:"index"`
+	ID        string              `json:"id"`
+	Score     float32             `json:"score"`
+	Document  search.Document     `json:"document"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+	// Item is the current DynamoDB item, only set with hydrate=true
+	Item map[string]interface{} `json:"item,omitempty"`
+}
+
+type FacetBucket struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func initClient(ctx context.Context) error {
+	if osClient != nil {
+		return nil
+	}
+
+	var err error
+	osClient, err = search.NewOpenSearchClient(ctx, opensearchEndpoint)
+	return err
+}
+
+func handler(ctx context.Context, request events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
+	if err := initClient(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error creating opensearch client", "error", err)
+		return jsonResponse(500, errorBody{Error: "search is unavailable"})
+	}
+
+	params, err := parseSearchParams(request.RawQueryString)
+	if err != nil {
+		return jsonResponse(400, errorBody{Error: err.Error()})
+	}
+
+	result, err := searchDocuments(ctx, params)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error searching documents", "error", err)
+		return jsonResponse(502, errorBody{Error: "search failed"})
+	}
+
+	if params.Hydrate {
+		if err := hydrate(ctx, result.Hits); err != nil {
+			slog.ErrorContext(ctx, "Error hydrating hits", "error", err)
+			return jsonResponse(502, errorBody{Error: "hydration failed"})
+		}
+	}
+
+	slog.InfoContext(ctx, "Search returned hits", "hits", len(result.Hits), "total", result.Total)
+	return jsonResponse(200, result)
+}
+
+func searchDocuments(ctx context.Context, params searchParams) (*SearchResult, error) {
+	body, err := json.Marshal(buildSearchBody(params))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal search body: %w", err)
+	}
+
+	resp, err := osClient.Search(ctx, &opensearchapi.SearchReq{
+		Indices: opensearchIndices,
+		Body:    bytes.NewReader(body),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	result := &SearchResult{
+		Total: resp.Hits.Total.Value,
+		Hits:  make([]Hit, 0, len(resp.Hits.Hits)),
+	}
+	for _, searchHit := range resp.Hits.Hits {
+		hit := Hit{
+			Index:     searchHit.Index,
+			ID:        searchHit.ID,
+			Score:     searchHit.Score,
+			Highlight: searchHit.Highlight,
+		}
+		if err := json.Unmarshal(searchHit.Source, &hit.Document); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal document %s: %w", searchHit.ID, err)
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+
+	// A full page may be followed by another one
+	if n := len(resp.Hits.Hits); n == params.Size {
+		result.Next, err = encodeCursor(resp.Hits.Hits[n-1].Sort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(resp.Aggregations) > 0 {
+		result.Facets, err = parseFacets(resp.Aggregations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func parseFacets(aggregations json.RawMessage) (map[string][]FacetBucket, error) {
+	var terms map[string]struct {
+		Buckets []struct {
+			Key      interface{} `json:"key"`
+			DocCount int         `json:"doc_count"`
+		} `json:"buckets"`
+	}
+	if err := json.Unmarshal(aggregations, &terms); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal aggregations: %w", err)
+	}
+
+	facets := make(map[string][]FacetBucket, len(terms))
+	for name, agg := range terms {
+		buckets := make([]FacetBucket, 0, len(agg.Buckets))
+		for _, bucket := range agg.Buckets {
+			buckets = append(buckets, FacetBucket{Value: bucket.Key, Count: bucket.DocCount})
+		}
+		facets[name] = buckets
+	}
+	return facets, nil
+}
+
+// hydrate reads the current DynamoDB item of every hit with a BatchGetItem,
+// a page holds at most 100 hits which is also the BatchGetItem limit
+func hydrate(ctx context.Context, hits []Hit) error {
+	if len(hits) == 0 {
+		return nil
+	}
+
+	keys := make([]map[string]types.AttributeValue, 0, len(hits))
+	seen := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		pk, sk := hitKey(hit)
+		if seen[pk+"\x00"+sk] {
+			continue
+		}
+		seen[pk+"\x00"+sk] = true
+		keys = append(keys, map[string]types.AttributeValue{
+			"pk": &types.AttributeValueMemberS{Value: pk},
+			"sk": &types.AttributeValueMemberS{Value: sk},
+		})
+	}
+
+	items := make(map[string]map[string]interface{}, len(keys))
+	request := map[string]types.KeysAndAttributes{tableName: {Keys: keys}}
+	for attempt := 0; len(request) > 0; attempt++ {
+		if attempt == 3 {
+			return fmt.Errorf("failed to get %d items after %d attempts", len(request[tableName].Keys), attempt)
+		}
+
+		output, err := dynamodbClient.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+		if err != nil {
+			return fmt.Errorf("failed to batch get items: %w", err)
+		}
+
+		for _, av := range output.Responses[tableName] {
+			var item map[string]interface{}
+			if err := attributevalue.UnmarshalMap(av, &item); err != nil {
+				return fmt.Errorf("failed to unmarshal item: %w", err)
+			}
+			items[fmt.Sprintf("%v\x00%v", item["pk"], item["sk"])] = item
+		}
+		request = output.UnprocessedKeys
+	}
+
+	for i := range hits {
+		pk, sk := hitKey(hits[i])
+		hits[i].Item = items[pk+"\x00"+sk]
+	}
+	return nil
+}
+
+// hitKey reads the table key of the hit, _ddb_key is missing on documents
+// indexed before it was introduced
+func hitKey(hit Hit) (string, string) {
+	if key := hit.Document.DDBKey; key != nil {
+		return key["pk"], key["sk"]
+	}
+	return hit.Document.PK, hit.Document.SK
+}
+
+func jsonResponse(statusCode int, body interface{}) (events.LambdaFunctionURLResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return events.LambdaFunctionURLResponse{}, fmt.Errorf("failed to marshal response: %w", err)
+	}
+
+	return events.LambdaFunctionURLResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSize = 20
+	maxSize     = 100
+	maxFacets   = 10
+	facetSize   = 10
+)
+
+// searchParams are the query string parameters of the search API:
+//
+//	q         full-text query over the data fields (simple_query_string syntax)
+//	pk, sk    exact key filters, skPrefix filters sort keys by prefix
+//	filter.X  exact filter on the data field X, e.g. filter.status=ACTIVE
+//	facets    comma separated data fields to aggregate, e.g. facets=status,city
+//	size      page size, 20 by default and at most 100
+//	after     cursor returned as next by the previous page
+//	hydrate   true to add the DynamoDB item to every hit
+type searchParams struct {
+	Query    string
+	PK       string
+	SK       string
+	SKPrefix string
+	Filters  map[string]string
+	Facets   []string
+	Size     int
+	After    []interface{}
+	Hydrate  bool
+}
+
+func parseSearchParams(rawQuery string) (searchParams, error) {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return searchParams{}, fmt.Errorf("invalid query string: %w", err)
+	}
+
+	params := searchParams{
+		Query:    values.Get("q"),
+		PK:       values.Get("pk"),
+		SK:       values.Get("sk"),
+		SKPrefix: values.Get("skPrefix"),
+		Filters:  make(map[string]string),
+		Size:     defaultSize,
+		Hydrate:  values.Get("hydrate") == "true",
+	}
+
+	for key := range values {
+		if field, ok := strings.CutPrefix(key, "filter."); ok && field != "" {
+			params.Filters[field] = values.Get(key)
+		}
+	}
+
+	if facets := values.Get("facets"); facets != "" {
+		for _, facet := range strings.Split(facets, ",") {
+			if facet = strings.TrimSpace(facet); facet != "" {
+				params.Facets = append(params.Facets, facet)
+			}
+		}
+		if len(params.Facets) > maxFacets {
+			return searchParams{}, fmt.Errorf("at most %d facets are allowed", maxFacets)
+		}
+	}
+
+	if size := values.Get("size"); size != "" {
+		params.Size, err = strconv.Atoi(size)
+		if err != nil || params.Size < 1 || params.Size > maxSize {
+			return searchParams{}, fmt.Errorf("size must be between 1 and %d", maxSize)
+		}
+	}
+
+	if after := values.Get("after"); after != "" {
+		params.After, err = decodeCursor(after)
+		if err != nil {
+			return searchParams{}, err
+		}
+	}
+
+	return params, nil
+}
+
+// buildSearchBody translates the parameters to an OpenSearch query. Hits are
+// sorted by score then by key, the key tiebreaker keeps search_after stable.
+func buildSearchBody(params searchParams) map[string]interface{} {
+	must := []interface{}{}
+	if params.Query != "" {
+		must = append(must, map[string]interface{}{
+			"simple_query_string": map[string]interface{}{
+				"query":  params.Query,
+				"fields": []string{"data.*"},
+			},
+		})
+	} else {
+		must = append(must, map[string]interface{}{"match_all": map[string]interface{}{}})
+	}
+
+	filter := []interface{}{}
+	if params.PK != "" {
+		filter = append(filter, term("pk.keyword", params.PK))
+	}
+	if params.SK != "" {
+		filter = append(filter, term("sk.keyword", params.SK))
+	}
+	if params.SKPrefix != "" {
+		filter = append(filter, map[string]interface{}{
+			"prefix": map[string]interface{}{"sk.keyword": params.SKPrefix},
+		})
+	}
+	for field, value := range params.Filters {
+		filter = append(filter, term("data."+field+".keyword", value))
+	}
+
+	body := map[string]interface{}{
+		"size": params.Size,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":   must,
+				"filter": filter,
+				// Documents kept by the mark remove policy are not search results
+				"must_not": []interface{}{term("deleted", true)},
+			},
+		},
+		"sort": []interface{}{
+			map[string]interface{}{"_score": "desc"},
+			map[string]interface{}{"pk.keyword": "asc"},
+			map[string]interface{}{"sk.keyword": "asc"},
+		},
+		"highlight": map[string]interface{}{
+			"fields": map[string]interface{}{"data.*": map[string]interface{}{}},
+		},
+	}
+
+	if len(params.After) > 0 {
+		body["search_after"] = params.After
+	}
+
+	if len(params.Facets) > 0 {
+		aggs := make(map[string]interface{}, len(params.Facets))
+		for _, facet := range params.Facets {
+			aggs[facet] = map[string]interface{}{
+				"terms": map[string]interface{}{"field": "data." + facet + ".keyword", "size": facetSize},
+			}
+		}
+		body["aggs"] = aggs
+	}
+
+	return body
+}
+
+func term(field string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{field: value},
+	}
+}
+
+// The cursor is the sort values of the last hit, opaque for the API clients
+func encodeCursor(sort []interface{}) (string, error) {
+	raw, err := json.Marshal(sort)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(cursor string) ([]interface{}, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+
+	var sort []interface{}
+	if err := json.Unmarshal(raw, &sort); err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return sort, nil
+}
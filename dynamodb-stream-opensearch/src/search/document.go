@@ -0,0 +1,16 @@
+package search
+
+// Document represents the structure to index in OpenSearch or any other search sink
+type Document struct {
+	PK string `json:"pk"`
+	SK string `json:"sk"`
+	// Original table key, always recoverable even when the document ID is hashed
+	DDBKey    map[string]string      `json:"_ddb_key"`
+	Data      map[string]interface{} `json:"data"`
+	Timestamp string                 `json:"timestamp"`
+	// Set when the item was removed from the table and the removal policy
+	// keeps the document (mark or archive)
+	Deleted     bool   `json:"deleted,omitempty"`
+	DeletedAt   string `json:"deletedAt,omitempty"`
+	DeleteCause string `json:"deleteCause,omitempty"`
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/opensearch-project/opensearch-go/v4"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
+	requestsigner "github.com/opensearch-project/opensearch-go/v4/signer/awsv2"
+)
+
+const (
+	SigningSigV4 = "sigv4"
+	SigningNone  = "none"
+)
+
+// OpenSearchCredentials is the basic auth user of the fine-grained access
+// control internal database, also the format of the Secrets Manager secret
+type OpenSearchCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// NewOpenSearchClient creates the client of the OpenSearch endpoint, by
+// default an Amazon OpenSearch Service domain with SigV4 signed requests.
+// Signing, basic auth and TLS verification are read from the environment:
+// OPENSEARCH_SIGNING, OPENSEARCH_USERNAME, OPENSEARCH_PASSWORD,
+// OPENSEARCH_CREDENTIALS_SECRET and OPENSEARCH_INSECURE_SKIP_VERIFY.
+// Options can adjust the client config, e.g. the transport retries.
+func NewOpenSearchClient(ctx context.Context, endpoint string, options ...func(*opensearch.Config)) (*opensearchapi.Client, error) {
+	clientConfig := opensearch.Config{
+		Addresses: []string{OpenSearchAddress(endpoint)},
+	}
+
+	// Skipping TLS verification is only meant for local containers with self-signed certificates
+	if os.Getenv("OPENSEARCH_INSECURE_SKIP_VERIFY") == "true" {
+		clientConfig.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+
+	signing := os.Getenv("OPENSEARCH_SIGNING")
+	secretID := os.Getenv("OPENSEARCH_CREDENTIALS_SECRET")
+
+	var awsConfig aws.Config
+	if signing != SigningNone || secretID != "" {
+		var err error
+		awsConfig, err = config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config: %w", err)
+		}
+	}
+
+	switch signing {
+	case "", SigningSigV4:
+		signer, err := requestsigner.NewSignerWithService(awsConfig, "es") // "es" for OpenSearch Service
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request signer: %w", err)
+		}
+		clientConfig.Signer = signer
+	case SigningNone:
+	default:
+		return nil, fmt.Errorf("unknown OPENSEARCH_SIGNING %q, expected sigv4 or none", signing)
+	}
+
+	credentials, err := LoadOpenSearchCredentials(ctx, awsConfig, secretID)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig.Username = credentials.Username
+	clientConfig.Password = credentials.Password
+
+	for _, option := range options {
+		option(&clientConfig)
+	}
+
+	client, err := opensearchapi.NewClient(opensearchapi.Config{Client: clientConfig})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create opensearch client: %w", err)
+	}
+	return client, nil
+}
+
+// OpenSearchAddress keeps the scheme of the endpoint, e.g. http://localhost:9200
+// for a local container, and defaults to https for bare domain endpoints
+func OpenSearchAddress(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return fmt.Sprintf("https://%s", endpoint)
+}
+
+// LoadOpenSearchCredentials reads the basic auth credentials from the Secrets
+// Manager secret when set, from OPENSEARCH_USERNAME and OPENSEARCH_PASSWORD otherwise
+func LoadOpenSearchCredentials(ctx context.Context, awsConfig aws.Config, secretID string) (OpenSearchCredentials, error) {
+	if secretID == "" {
+		return OpenSearchCredentials{
+			Username: os.Getenv("OPENSEARCH_USERNAME"),
+			Password: os.Getenv("OPENSEARCH_PASSWORD"),
+		}, nil
+	}
+
+	secret, err := secretsmanager.NewFromConfig(awsConfig).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return OpenSearchCredentials{}, fmt.Errorf("failed to get opensearch credentials secret: %w", err)
+	}
+
+	var credentials OpenSearchCredentials
+	if err := json.Unmarshal([]byte(aws.ToString(secret.SecretString)), &credentials); err != nil {
+		return OpenSearchCredentials{}, fmt.Errorf("failed to parse opensearch credentials secret: %w", err)
+	}
+	return credentials, nil
+}
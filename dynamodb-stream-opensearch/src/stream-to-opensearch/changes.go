@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// ChangeEvent is the normalized change data capture event published for
+// every stream record, independently from the search sink projection
+type ChangeEvent struct {
+	Entity         string                 `json:"entity"`
+	Op             string                 `json:"op"` // insert, modify or remove
+	Keys           map[string]interface{} `json:"keys"`
+	Before         map[string]interface{} `json:"before,omitempty"`
+	After          map[string]interface{} `json:"after,omitempty"`
+	SequenceNumber string                 `json:"sequenceNumber"`
+	// Truncated is set when the images were dropped to fit the target size
+	// limit, subscribers read the item from the table instead
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func newChangeEvent(record changeRecord) ChangeEvent {
+	image := record.NewImage
+	if len(image) == 0 {
+		image = record.OldImage
+	}
+
+	return ChangeEvent{
+		Entity:         entityName(image),
+		Op:             strings.ToLower(record.EventName),
+		Keys:           imageToMap(record.Keys),
+		Before:         imageToMap(record.OldImage),
+		After:          imageToMap(record.NewImage),
+		SequenceNumber: record.SequenceNumber,
+	}
+}
+
+// entityName reads the entityType attribute of single-table designs and
+// falls back to the pk prefix, "USER" for "USER#42"
+func entityName(image map[string]events.DynamoDBAttributeValue) string {
+	if entityType := getStringValue(image["entityType"]); entityType != "" {
+		return entityType
+	}
+	prefix, _, _ := strings.Cut(getStringValue(image["pk"]), "#")
+	return prefix
+}
+
+func imageToMap(image map[string]events.DynamoDBAttributeValue) map[string]interface{} {
+	if len(image) == 0 {
+		return nil
+	}
+
+	result := make(map[string]interface{}, len(image))
+	for key, value := range image {
+		result[key] = attributeValueToInterface(value)
+	}
+	return result
+}
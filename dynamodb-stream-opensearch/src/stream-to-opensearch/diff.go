@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	search "dynamodb-stream-opensearch-search"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// parseIndexedFields reads a comma separated list of attributes copied to the
+// document data, an empty value means every attribute is indexed.
+func parseIndexedFields(raw string) map[string]bool {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+
+	fields := make(map[string]bool)
+	for _, field := range strings.Split(raw, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+func isIndexedField(name string) bool {
+	return indexedFields == nil || indexedFields[name]
+}
+
+// changedFields diffs the indexed attributes of both images of a MODIFY
+// record. It returns the new value of every added or updated attribute and
+// whether the document must be put whole: a partial _update merges objects
+// recursively, so it can neither drop a removed attribute nor a key removed
+// from a map attribute.
+func changedFields(oldImage, newImage map[string]events.DynamoDBAttributeValue) (map[string]interface{}, bool) {
+	changed := make(map[string]interface{})
+	needsPut := false
+	for key, value := range newImage {
+		if !isIndexedField(key) {
+			continue
+		}
+		if oldValue, ok := oldImage[key]; !ok || !reflect.DeepEqual(oldValue, value) {
+			changed[key] = attributeValueToInterface(value)
+			if value.DataType() == events.DataTypeMap || (ok && oldValue.DataType() == events.DataTypeMap) {
+				needsPut = true
+			}
+		}
+	}
+
+	for key := range oldImage {
+		if _, ok := newImage[key]; !ok && isIndexedField(key) {
+			needsPut = true
+			break
+		}
+	}
+	return changed, needsPut
+}
+
+// updateDocument sends only the changed fields with a partial _update, the
+// full document is used as upsert in case it was never indexed.
+func updateDocument(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error {
+	if err := sink.Update(ctx, index, docID, changed, doc); err != nil {
+		return fmt.Errorf("failed to update document %s in %s: %w", docID, index, err)
+	}
+
+	slog.InfoContext(ctx, "Updated document fields", "index", index, "doc_id", docID, "fields", len(changed))
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Document IDs end up in the request path (/<index>/_doc/<id>), so they are
+// restricted to URL unreserved characters. Every other byte of the pk and sk
+// is escaped as ~HH and both parts are joined with a dot, which is always
+// escaped inside the parts, so the encoding is collision free and reversible:
+//
+//	USER#42 / PROFILE#a/b  ->  USER~2342.PROFILE~23a~2Fb
+//
+// OpenSearch rejects IDs longer than 512 bytes, long encoded keys are
+// truncated and suffixed with ~h and the SHA-256 of the full encoding. The
+// original key can then only be read from the _ddb_key document field.
+const (
+	docIDEscape       = '~'
+	docIDSeparator    = '.'
+	docIDHashMarker   = "~h"
+	docIDMaxLength    = 512
+	docIDHashedPrefix = 256
+)
+
+const upperHex = "0123456789ABCDEF"
+
+// encodeDocID builds the OpenSearch document ID of the item with the given
+// partition and sort keys, it is shared by the index and delete paths.
+func encodeDocID(pk string, sk string) string {
+	id := escapeDocIDPart(pk) + string(docIDSeparator) + escapeDocIDPart(sk)
+	if len(id) <= docIDMaxLength {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return id[:docIDHashedPrefix] + docIDHashMarker + hex.EncodeToString(sum[:])
+}
+
+// decodeDocID returns the partition and sort keys of a document ID, ok is
+// false for hashed IDs of long keys and for IDs not built by encodeDocID.
+func decodeDocID(id string) (pk string, sk string, ok bool) {
+	if strings.Contains(id, docIDHashMarker) {
+		return "", "", false
+	}
+
+	escapedPK, escapedSK, found := strings.Cut(id, string(docIDSeparator))
+	if !found {
+		return "", "", false
+	}
+
+	pk, ok = unescapeDocIDPart(escapedPK)
+	if !ok {
+		return "", "", false
+	}
+	sk, ok = unescapeDocIDPart(escapedSK)
+	return pk, sk, ok
+}
+
+func isDocIDSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_'
+}
+
+func escapeDocIDPart(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDocIDSafe(c) {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte(docIDEscape)
+		b.WriteByte(upperHex[c>>4])
+		b.WriteByte(upperHex[c&0x0F])
+	}
+	return b.String()
+}
+
+func unescapeDocIDPart(s string) (string, bool) {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDocIDSafe(c) {
+			b.WriteByte(c)
+			continue
+		}
+		if c != docIDEscape || i+2 >= len(s) {
+			return "", false
+		}
+		hi := strings.IndexByte(upperHex, s[i+1])
+		lo := strings.IndexByte(upperHex, s[i+2])
+		if hi < 0 || lo < 0 {
+			return "", false
+		}
+		b.WriteByte(byte(hi<<4 | lo))
+		i += 2
+	}
+	return b.String(), true
+}
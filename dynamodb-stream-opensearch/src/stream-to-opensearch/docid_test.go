@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocIDRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		pk   string
+		sk   string
+		want string
+	}{
+		{name: "single-table keys", pk: "USER#42", sk: "PROFILE", want: "USER~2342.PROFILE"},
+		{name: "slash", pk: "USER#42", sk: "PROFILE#a/b", want: "USER~2342.PROFILE~23a~2Fb"},
+		{name: "separator in the keys", pk: "a.b", sk: "c", want: "a~2Eb.c"},
+		{name: "escape character in the keys", pk: "a~2E", sk: "~", want: "a~7E2E.~7E"},
+		{name: "unreserved characters", pk: "a-b_c", sk: "0-9", want: "a-b_c.0-9"},
+		{name: "multibyte characters", pk: "CITY#Zürich", sk: "", want: "CITY~23Z~C3~BCrich."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := encodeDocID(tt.pk, tt.sk)
+			if id != tt.want {
+				t.Errorf("encodeDocID(%q, %q) = %q, want %q", tt.pk, tt.sk, id, tt.want)
+			}
+
+			pk, sk, ok := decodeDocID(id)
+			if !ok || pk != tt.pk || sk != tt.sk {
+				t.Errorf("decodeDocID(%q) = %q, %q, %v, want %q, %q, true", id, pk, sk, ok, tt.pk, tt.sk)
+			}
+		})
+	}
+}
+
+// Keys the previous normalization mapped to the same ID must not collide
+func TestDocIDCollisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  [2]string
+		second [2]string
+	}{
+		{name: "slash and dash", first: [2]string{"a/b#c", "x"}, second: [2]string{"a-b#c", "x"}},
+		{name: "separator moved between the keys", first: [2]string{"a.b", "c"}, second: [2]string{"a", "b.c"}},
+		{name: "dot and tilde", first: [2]string{"a.b", "c"}, second: [2]string{"a~b", "c"}},
+		{name: "escape sequence in the key", first: [2]string{"a~2Eb", "c"}, second: [2]string{"a.b", "c"}},
+		{name: "long keys with a shared prefix", first: [2]string{strings.Repeat("a", 600) + "1", "x"}, second: [2]string{strings.Repeat("a", 600) + "2", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := encodeDocID(tt.first[0], tt.first[1])
+			second := encodeDocID(tt.second[0], tt.second[1])
+			if first == second {
+				t.Errorf("%q and %q both encode to %q", tt.first, tt.second, first)
+			}
+		})
+	}
+}
+
+func TestDocIDLongKeys(t *testing.T) {
+	pk := strings.Repeat("#", 300) // escaped to 900 bytes
+	id := encodeDocID(pk, "SK")
+
+	if len(id) > docIDMaxLength {
+		t.Errorf("len(encodeDocID) = %d, want at most %d", len(id), docIDMaxLength)
+	}
+	if !strings.Contains(id, docIDHashMarker) {
+		t.Errorf("encodeDocID = %q, want a hashed ID", id)
+	}
+	if id != encodeDocID(pk, "SK") {
+		t.Error("encodeDocID is not deterministic for long keys")
+	}
+	if _, _, ok := decodeDocID(id); ok {
+		t.Errorf("decodeDocID(%q) ok, want false for a hashed ID", id)
+	}
+
+	// A key escaped to exactly the limit is kept as is
+	exact := strings.Repeat("a", docIDMaxLength-len(".SK"))
+	if id := encodeDocID(exact, "SK"); len(id) != docIDMaxLength || strings.Contains(id, docIDHashMarker) {
+		t.Errorf("encodeDocID of a %d bytes ID = %q, want it unhashed", docIDMaxLength, id)
+	}
+}
+
+func TestDecodeDocIDInvalid(t *testing.T) {
+	for _, id := range []string{
+		"USER",        // no separator
+		"USER~ZZ.SK",  // invalid escape
+		"USER~2.SK",   // truncated escape
+		"USER.SK~2",   // truncated escape at the end
+		"USER.SK.X",   // unescaped separator
+		"USER/42.SK",  // unsafe character
+		"USER~2f.SK",  // lower case hex is never produced
+		"USER.SK~h00", // hashed ID
+	} {
+		if pk, sk, ok := decodeDocID(id); ok {
+			t.Errorf("decodeDocID(%q) = %q, %q, true, want false", id, pk, sk)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	eventSourceDynamoDB = "aws:dynamodb"
+	eventSourceKinesis  = "aws:kinesis"
+)
+
+// changeRecord is the item change processed by the handler, normalized from
+// the DynamoDB Streams and the Kinesis Data Streams for DynamoDB envelopes
+type changeRecord struct {
+	EventID   string
+	EventName string // INSERT, MODIFY or REMOVE
+	Keys      map[string]events.DynamoDBAttributeValue
+	NewImage  map[string]events.DynamoDBAttributeValue
+	OldImage  map[string]events.DynamoDBAttributeValue
+	// UserIdentity is set for the removals done by DynamoDB TTL
+	UserIdentity *events.DynamoDBUserIdentity
+	// CreatedAt is the approximate time of the change, with a second
+	// precision for DynamoDB Streams and a millisecond one for Kinesis
+	CreatedAt      time.Time
+	SequenceNumber string
+	// ExternalVersion orders the writes of the Kinesis records, which can be
+	// delivered out of order or more than once, 0 for DynamoDB Streams
+	ExternalVersion int64
+}
+
+type externalVersionKey struct{}
+
+// withExternalVersion makes the sinks supporting it write with an external
+// version: a write older than the indexed document is rejected with a conflict
+func withExternalVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, externalVersionKey{}, version)
+}
+
+func externalVersion(ctx context.Context) (int64, bool) {
+	version, ok := ctx.Value(externalVersionKey{}).(int64)
+	return version, ok
+}
+
+// kinesisChange is the DynamoDB change record carried by the Kinesis record data
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/kds.html
+type kinesisChange struct {
+	EventID      string                       `json:"eventID"`
+	EventName    string                       `json:"eventName"`
+	UserIdentity *events.DynamoDBUserIdentity `json:"userIdentity"`
+	DynamoDB     struct {
+		// Epoch milliseconds, or microseconds depending on the precision
+		ApproximateCreationDateTime          int64                                    `json:"ApproximateCreationDateTime"`
+		ApproximateCreationDateTimePrecision string                                   `json:"ApproximateCreationDateTimePrecision"`
+		Keys                                 map[string]events.DynamoDBAttributeValue `json:"Keys"`
+		NewImage                             map[string]events.DynamoDBAttributeValue `json:"NewImage"`
+		OldImage                             map[string]events.DynamoDBAttributeValue `json:"OldImage"`
+	} `json:"dynamodb"`
+}
+
+// parseEvent reads a DynamoDB Streams or a Kinesis event, told apart by the
+// eventSource of the records
+func parseEvent(payload json.RawMessage) ([]changeRecord, error) {
+	var envelope struct {
+		Records []struct {
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	if len(envelope.Records) == 0 {
+		return nil, nil
+	}
+
+	switch source := envelope.Records[0].EventSource; source {
+	case eventSourceDynamoDB:
+		var event events.DynamoDBEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse dynamodb event: %w", err)
+		}
+		records := make([]changeRecord, 0, len(event.Records))
+		for _, record := range event.Records {
+			records = append(records, fromDynamoDBRecord(record))
+		}
+		return records, nil
+	case eventSourceKinesis:
+		var event events.KinesisEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse kinesis event: %w", err)
+		}
+		records := make([]changeRecord, 0, len(event.Records))
+		for _, record := range event.Records {
+			change, err := fromKinesisRecord(record)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, change)
+		}
+		return records, nil
+	default:
+		return nil, fmt.Errorf("unsupported event source %q", source)
+	}
+}
+
+func fromDynamoDBRecord(record events.DynamoDBEventRecord) changeRecord {
+	return changeRecord{
+		EventID:        record.EventID,
+		EventName:      record.EventName,
+		Keys:           record.Change.Keys,
+		NewImage:       record.Change.NewImage,
+		OldImage:       record.Change.OldImage,
+		UserIdentity:   record.UserIdentity,
+		CreatedAt:      record.Change.ApproximateCreationDateTime.Time,
+		SequenceNumber: record.Change.SequenceNumber,
+	}
+}
+
+func fromKinesisRecord(record events.KinesisEventRecord) (changeRecord, error) {
+	var change kinesisChange
+	if err := json.Unmarshal(record.Kinesis.Data, &change); err != nil {
+		return changeRecord{}, fmt.Errorf("failed to parse kinesis record %s: %w", record.EventID, err)
+	}
+
+	var createdAt time.Time
+	if change.DynamoDB.ApproximateCreationDateTimePrecision == "MICROSECOND" {
+		createdAt = time.UnixMicro(change.DynamoDB.ApproximateCreationDateTime)
+	} else {
+		createdAt = time.UnixMilli(change.DynamoDB.ApproximateCreationDateTime)
+	}
+
+	return changeRecord{
+		EventID:        change.EventID,
+		EventName:      change.EventName,
+		Keys:           change.DynamoDB.Keys,
+		NewImage:       change.DynamoDB.NewImage,
+		OldImage:       change.DynamoDB.OldImage,
+		UserIdentity:   change.UserIdentity,
+		CreatedAt:      createdAt,
+		SequenceNumber: record.Kinesis.SequenceNumber,
+		// Microseconds keep the versions above the internal ones of the
+		// documents indexed before
+		ExternalVersion: createdAt.UnixMicro(),
+	}, nil
+}
@@ -2,171 +2,270 @@ package main
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"regexp"
+	"strings"
 	"time"
 
+	lambdalog "aws-labs-lambdalog"
+	search "dynamodb-stream-opensearch-search"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/opensearch-project/opensearch-go/v4"
-	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
-	"github.com/opensearch-project/opensearch-go/v4/opensearchutil"
-	requestsigner "github.com/opensearch-project/opensearch-go/v4/signer/awsv2"
 )
 
 var (
 	opensearchEndpoint string
 	opensearchIndex    string
-	osClient           *opensearchapi.Client
+	tableName          string
+	reconcileIndices   []string
+	searchSinkKind     string
+	sink               SearchSink
+	sinkRetryPolicy    retryPolicy
+	sinkBreaker        *circuitBreaker
+	publisherKind      string
+	publisherTargetARN string
+	publisher          ChangePublisher
+	routingRules       []routingRule
+	userRemovePolicy   removePolicy
+	ttlRemovePolicy    removePolicy
+	archiveIndex       string
+	indexedFields      map[string]bool
+	partialUpdates     bool
+	metricsNamespace   string
+	lagWarnThreshold   time.Duration
 )
 
 func init() {
+	lambdalog.Setup()
+
 	opensearchEndpoint = os.Getenv("OPENSEARCH_ENDPOINT")
 	opensearchIndex = os.Getenv("OPENSEARCH_INDEX")
-}
-
-// Document represents the structure to index in OpenSearch
-type Document struct {
-	PK        string                 `json:"pk"`
-	SK        string                 `json:"sk"`
-	Data      map[string]interface{} `json:"data"`
-	Timestamp string                 `json:"timestamp"`
-}
+	tableName = os.Getenv("TABLE_NAME")
+	// Comma separated indices or patterns covering every routed index
+	reconcileIndices = []string{opensearchIndex}
+	if indices := os.Getenv("RECONCILE_INDICES"); indices != "" {
+		reconcileIndices = strings.Split(indices, ",")
+	}
+	searchSinkKind = os.Getenv("SEARCH_SINK")
+	publisherKind = os.Getenv("CHANGE_PUBLISHER")
+	publisherTargetARN = os.Getenv("CHANGE_PUBLISHER_TARGET_ARN")
 
-func initClient(ctx context.Context) error {
-	if osClient != nil {
-		return nil
+	metricsNamespace = os.Getenv("METRICS_NAMESPACE")
+	if metricsNamespace == "" {
+		metricsNamespace = defaultMetricsNamespace
 	}
 
-	cfg, err := config.LoadDefaultConfig(ctx)
+	var err error
+	lagWarnThreshold, err = envDuration("STREAM_LAG_WARN_THRESHOLD", time.Minute)
 	if err != nil {
-		return fmt.Errorf("failed to load AWS config: %w", err)
+		lambdalog.Fatal("Invalid STREAM_LAG_WARN_THRESHOLD", "error", err)
+	}
+	sinkRetryPolicy, err = parseRetryPolicy()
+	if err != nil {
+		lambdalog.Fatal("Invalid search sink retry policy", "error", err)
+	}
+	sinkBreaker, err = parseCircuitBreaker()
+	if err != nil {
+		lambdalog.Fatal("Invalid search sink circuit breaker", "error", err)
 	}
 
-	signer, err := requestsigner.NewSignerWithService(cfg, "es") // "es" for OpenSearch Service
+	routingRules, err = parseRoutingRules(os.Getenv("OPENSEARCH_ROUTING_RULES"))
 	if err != nil {
-		return fmt.Errorf("failed to create request signer: %w", err)
+		lambdalog.Fatal("Invalid OPENSEARCH_ROUTING_RULES", "error", err)
 	}
 
-	osClient, err = opensearchapi.NewClient(
-		opensearchapi.Config{
-			Client: opensearch.Config{
-				Addresses: []string{fmt.Sprintf("https://%s", opensearchEndpoint)},
-				Signer:    signer,
-			},
-		},
-	)
+	indexedFields = parseIndexedFields(os.Getenv("OPENSEARCH_INDEXED_FIELDS"))
+	partialUpdates = os.Getenv("OPENSEARCH_PARTIAL_UPDATES") == "true"
+
+	archiveIndex = os.Getenv("OPENSEARCH_ARCHIVE_INDEX")
+	userRemovePolicy, err = parseRemovePolicy(os.Getenv("REMOVE_POLICY"))
 	if err != nil {
-		return fmt.Errorf("failed to create opensearch client: %w", err)
+		lambdalog.Fatal("Invalid REMOVE_POLICY", "error", err)
+	}
+	ttlRemovePolicy, err = parseRemovePolicy(os.Getenv("TTL_REMOVE_POLICY"))
+	if err != nil {
+		lambdalog.Fatal("Invalid TTL_REMOVE_POLICY", "error", err)
+	}
+	if (userRemovePolicy == removePolicyArchive || ttlRemovePolicy == removePolicyArchive) && archiveIndex == "" {
+		lambdalog.Fatal("OPENSEARCH_ARCHIVE_INDEX environment variable is required by the archive remove policy")
+	}
+}
+
+func initClients(ctx context.Context) error {
+	if sink != nil {
+		return nil
 	}
 
+	var err error
+	if publisher, err = newChangePublisher(ctx, publisherKind, publisherTargetARN); err != nil {
+		return err
+	}
+	next, err := newSearchSink(ctx, searchSinkKind)
+	if err != nil {
+		return err
+	}
+	sink = newRetryingSink(next, sinkRetryPolicy, sinkBreaker)
 	return nil
 }
 
-// normalizeDocID removes or replaces forbidden characters in OpenSearch document IDs
-func normalizeDocID(id string) string {
-	id = regexp.MustCompile(`[/\\]`).ReplaceAllString(id, "-")
-	id = regexp.MustCompile(`\s+`).ReplaceAllString(id, "-")
-	id = regexp.MustCompile(`\x00`).ReplaceAllString(id, "")
-	id = regexp.MustCompile(`-+`).ReplaceAllString(id, "-")
-	id = regexp.MustCompile(`^-|-$`).ReplaceAllString(id, "")
-	return id
-}
+// handler accepts DynamoDB Streams and Kinesis Data Streams for DynamoDB events
+func handler(ctx context.Context, payload json.RawMessage) error {
+	records, err := parseEvent(payload)
+	if err != nil {
+		return err
+	}
 
-func handler(ctx context.Context, event events.DynamoDBEvent) error {
-	if err := initClient(ctx); err != nil {
+	// The lag is emitted on the failure paths too, the records left
+	// unprocessed are measured against now so a stalled index keeps
+	// reporting a growing lag while the batch is retried
+	lags := make([]time.Duration, 0, len(records))
+	defer func() {
+		now := time.Now()
+		for _, record := range records[len(lags):] {
+			lags = append(lags, streamLag(record, now))
+		}
+		emitLagMetrics(ctx, lags)
+	}()
+
+	if err := initClients(ctx); err != nil {
 		return err
 	}
 
-	for _, record := range event.Records {
-		log.Printf("Processing record: %s, Event type: %s", record.EventID, record.EventName)
+	var changes []ChangeEvent
+
+	for _, record := range records {
+		recordCtx := lambdalog.With(ctx, lambdalog.KeyStreamEventID, record.EventID)
+		if record.ExternalVersion > 0 {
+			recordCtx = withExternalVersion(recordCtx, record.ExternalVersion)
+		}
+		slog.InfoContext(recordCtx, "Processing record", "event_name", record.EventName)
 
 		switch record.EventName {
 		case "INSERT", "MODIFY":
-			if err := indexDocument(ctx, record); err != nil {
-				log.Printf("Error indexing document: %v", err)
+			if err := indexDocument(recordCtx, record); err != nil {
+				slog.ErrorContext(recordCtx, "Error indexing document", "error", err)
 				return err
 			}
 		case "REMOVE":
-			if err := deleteDocument(ctx, record); err != nil {
-				log.Printf("Error deleting document: %v", err)
+			if err := deleteDocument(recordCtx, record); err != nil {
+				slog.ErrorContext(recordCtx, "Error deleting document", "error", err)
 				return err
 			}
 		}
+
+		lags = append(lags, streamLag(record, time.Now()))
+
+		if publisher != nil {
+			changes = append(changes, newChangeEvent(record))
+		}
+	}
+
+	if len(changes) > 0 {
+		if err := publisher.Publish(ctx, changes); err != nil {
+			slog.ErrorContext(ctx, "Error publishing change events", "error", err)
+			return err
+		}
+		slog.InfoContext(ctx, "Published change events", "count", len(changes))
 	}
 
-	log.Printf("Successfully processed %d records", len(event.Records))
+	slog.InfoContext(ctx, "Successfully processed records", "count", len(records))
 	return nil
 }
 
-func indexDocument(ctx context.Context, record events.DynamoDBEventRecord) error {
+func indexDocument(ctx context.Context, record changeRecord) error {
+	doc, docID := newDocument(record.NewImage)
+	index := resolveIndex(record.NewImage)
+
+	if len(record.OldImage) == 0 {
+		return putDocument(ctx, index, docID, &doc)
+	}
+
+	// The item moved to another index (e.g. its entity type or date changed),
+	// index the whole document and remove the stale copy left in the previous one
+	if oldIndex := resolveIndex(record.OldImage); oldIndex != index {
+		if err := putDocument(ctx, index, docID, &doc); err != nil {
+			return err
+		}
+		return deleteFromIndex(ctx, oldIndex, docID)
+	}
+
+	changed, needsPut := changedFields(record.OldImage, record.NewImage)
+	if len(changed) == 0 && !needsPut {
+		slog.InfoContext(ctx, "No indexed field change, skipping", "doc_id", docID)
+		return nil
+	}
+	// A partial update merges fields and cannot drop the removed ones, nor
+	// be versioned externally
+	_, versioned := externalVersion(ctx)
+	if partialUpdates && !needsPut && !versioned {
+		return updateDocument(ctx, index, docID, changed, &doc)
+	}
+	return putDocument(ctx, index, docID, &doc)
+}
+
+// newDocument converts a DynamoDB item image to the document indexed in
+// OpenSearch along with its document ID
+func newDocument(image map[string]events.DynamoDBAttributeValue) (search.Document, string) {
 	// Convert DynamoDB attribute values to a map
 	data := make(map[string]interface{})
-	for key, value := range record.Change.NewImage {
-		data[key] = attributeValueToInterface(value)
+	for key, value := range image {
+		if isIndexedField(key) {
+			data[key] = attributeValueToInterface(value)
+		}
 	}
 
-	pk := getStringValue(record.Change.NewImage["pk"])
-	sk := getStringValue(record.Change.NewImage["sk"])
+	pk := getStringValue(image["pk"])
+	sk := getStringValue(image["sk"])
 
-	doc := Document{
+	doc := search.Document{
 		PK:        pk,
 		SK:        sk,
+		DDBKey:    map[string]string{"pk": pk, "sk": sk},
 		Data:      data,
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
 
-	// Create document ID from pk and sk
-	docID := normalizeDocID(fmt.Sprintf("%s#%s", pk, sk))
+	return doc, encodeDocID(pk, sk)
+}
 
-	// Index the document using the OpenSearch client
-	_, err := osClient.Index(
-		ctx,
-		opensearchapi.IndexReq{
-			Index:      opensearchIndex,
-			DocumentID: docID,
-			Body:       opensearchutil.NewJSONReader(&doc),
-		},
-	)
-	if err != nil {
-		return fmt.Errorf("failed to index document: %w", err)
+func putDocument(ctx context.Context, index string, docID string, doc *search.Document) error {
+	if err := sink.Index(ctx, index, docID, doc); err != nil {
+		if isStaleChange(ctx, err) {
+			slog.WarnContext(ctx, "Skipping change older than the indexed document", "index", index, "doc_id", docID)
+			return nil
+		}
+		return fmt.Errorf("failed to index document %s in %s: %w", docID, index, err)
 	}
 
-	log.Printf("Indexed document %s successfully", docID)
+	slog.InfoContext(ctx, "Indexed document", "index", index, "doc_id", docID)
 	return nil
 }
 
-func deleteDocument(ctx context.Context, record events.DynamoDBEventRecord) error {
-	pk := getStringValue(record.Change.OldImage["pk"])
-	sk := getStringValue(record.Change.OldImage["sk"])
-	docID := normalizeDocID(fmt.Sprintf("%s#%s", pk, sk))
-
-	// Delete the document using the OpenSearch client
-	_, err := osClient.Document.Delete(
-		ctx,
-		opensearchapi.DocumentDeleteReq{
-			Index:      opensearchIndex,
-			DocumentID: docID,
-		},
-	)
+func deleteFromIndex(ctx context.Context, index string, docID string) error {
+	result, err := sink.Delete(ctx, index, docID)
 	if err != nil {
-		// Ignore 404 errors (document not found)
-		var opensearchErr *opensearch.StructError
-		if errors.As(err, &opensearchErr) && opensearchErr.Err.Type == "index_not_found_exception" {
-			log.Printf("Document %s not found, skipping delete", docID)
+		if isStaleChange(ctx, err) {
+			slog.WarnContext(ctx, "Skipping removal older than the indexed document", "index", index, "doc_id", docID)
 			return nil
 		}
-		return fmt.Errorf("failed to delete document: %w", err)
+		return fmt.Errorf("failed to delete document %s from %s: %w", docID, index, err)
 	}
 
-	log.Printf("Deleted document %s successfully", docID)
+	slog.InfoContext(ctx, "Delete result", "index", index, "doc_id", docID, "result", result)
 	return nil
 }
 
+// isStaleChange reports a version conflict of an externally versioned write,
+// a newer change of the item was already indexed
+func isStaleChange(ctx context.Context, err error) bool {
+	_, versioned := externalVersion(ctx)
+	return versioned && errorStatusCode(err) == http.StatusConflict
+}
+
 func attributeValueToInterface(av events.DynamoDBAttributeValue) interface{} {
 	switch av.DataType() {
 	case events.DataTypeString:
@@ -202,5 +301,10 @@ func getStringValue(av events.DynamoDBAttributeValue) string {
 }
 
 func main() {
+	// The same binary runs the reconciliation job, see reconcile.go
+	if os.Getenv("MODE") == "RECONCILE" {
+		lambda.Start(reconcileHandler)
+		return
+	}
 	lambda.Start(handler)
 }
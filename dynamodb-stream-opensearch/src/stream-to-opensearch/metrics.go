@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const defaultMetricsNamespace = "DynamoDBStreamOpenSearch"
+
+// streamLag is the delay between the item change, as stamped by the stream,
+// and its indexing
+func streamLag(record changeRecord, indexedAt time.Time) time.Duration {
+	if record.CreatedAt.IsZero() {
+		return 0
+	}
+	lag := indexedAt.Sub(record.CreatedAt)
+	if lag < 0 {
+		return 0
+	}
+	return lag
+}
+
+// maxMetricValues is the EMF limit of values of a metric in a log line
+const maxMetricValues = 100
+
+// emitLagMetrics writes the lag of every record of the batch as StreamLag
+// values, letting CloudWatch compute the percentiles over all the records,
+// with the batch StreamLagMax (the age of the oldest record) and
+// StreamRecords in the CloudWatch embedded metric format. The log lines are
+// turned into metrics by CloudWatch Logs without any API call. A warning is
+// logged when the max lag is above lagWarnThreshold.
+func emitLagMetrics(ctx context.Context, lags []time.Duration) {
+	if len(lags) == 0 {
+		return
+	}
+
+	var maxAge time.Duration
+	values := make([]int64, 0, len(lags))
+	for _, lag := range lags {
+		maxAge = max(maxAge, lag)
+		values = append(values, lag.Milliseconds())
+	}
+
+	for start := 0; start < len(values); start += maxMetricValues {
+		end := min(start+maxMetricValues, len(values))
+		metrics := []map[string]string{{"Name": "StreamLag", "Unit": "Milliseconds"}}
+		payload := map[string]interface{}{
+			"FunctionName": lambdacontext.FunctionName,
+			"StreamLag":    values[start:end],
+		}
+		// the batch values are written once
+		if start == 0 {
+			metrics = append(metrics,
+				map[string]string{"Name": "StreamLagMax", "Unit": "Milliseconds"},
+				map[string]string{"Name": "StreamRecords", "Unit": "Count"},
+			)
+			payload["StreamLagMax"] = maxAge.Milliseconds()
+			payload["StreamRecords"] = len(lags)
+		}
+		payload["_aws"] = map[string]interface{}{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{
+				{
+					"Namespace":  metricsNamespace,
+					"Dimensions": [][]string{{"FunctionName"}},
+					"Metrics":    metrics,
+				},
+			},
+		}
+
+		line, err := json.Marshal(payload)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to marshal lag metrics", "error", err)
+			return
+		}
+		// EMF lines must be written as is, not wrapped in a log record
+		fmt.Fprintln(os.Stdout, string(line))
+	}
+
+	if lagWarnThreshold > 0 && maxAge > lagWarnThreshold {
+		slog.WarnContext(ctx, "Index is lagging behind the table",
+			"lag_max_ms", maxAge.Milliseconds(),
+			"records", len(lags),
+			"threshold_ms", lagWarnThreshold.Milliseconds(),
+		)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventbridgetypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// ChangePublisher fans out change events to downstream subscribers. Events
+// are published after the batch is indexed, a retried batch publishes them
+// again so subscribers get at-least-once delivery.
+type ChangePublisher interface {
+	Publish(ctx context.Context, changes []ChangeEvent) error
+}
+
+const (
+	publisherEventBridge = "eventbridge"
+	publisherSNS         = "sns"
+	publisherKinesis     = "kinesis"
+)
+
+const changeEventSource = "dynamodb-stream-opensearch"
+
+// newChangePublisher creates the publisher selected by CHANGE_PUBLISHER, nil when disabled
+func newChangePublisher(ctx context.Context, kind string, targetARN string) (ChangePublisher, error) {
+	if kind == "" {
+		return nil, nil
+	}
+	if targetARN == "" {
+		return nil, fmt.Errorf("CHANGE_PUBLISHER_TARGET_ARN environment variable is required by the %s publisher", kind)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	switch kind {
+	case publisherEventBridge:
+		return &eventBridgePublisher{client: eventbridge.NewFromConfig(cfg), eventBusARN: targetARN}, nil
+	case publisherSNS:
+		return &snsPublisher{client: sns.NewFromConfig(cfg), topicARN: targetARN}, nil
+	case publisherKinesis:
+		return &kinesisPublisher{client: kinesis.NewFromConfig(cfg), streamARN: targetARN}, nil
+	default:
+		return nil, fmt.Errorf("unknown change publisher %q, expected eventbridge, sns or kinesis", kind)
+	}
+}
+
+// partitionKey keeps the changes of an item ordered on Kinesis shards and SNS
+// FIFO groups, long keys are hashed to fit the 128 characters group ID limit
+func partitionKey(change ChangeEvent) string {
+	key := fmt.Sprintf("%v#%v", change.Keys["pk"], change.Keys["sk"])
+	if len(key) > 128 {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// EventBridge PutEvents and SNS PublishBatch accept 256 KiB per request,
+// the margin is left for the other entry fields and attributes
+const maxEventBatchBytes = 240 * 1024
+
+// Kinesis PutRecords accepts 1 MiB per record and 5 MiB per request
+const (
+	maxKinesisRecordBytes = 1000 * 1024
+	maxKinesisBatchBytes  = 5000 * 1024
+)
+
+// encodedChange is a change event with its JSON payload
+type encodedChange struct {
+	change  ChangeEvent
+	payload []byte
+}
+
+// encodeChanges marshals the changes. An item can be up to 400 KB, so the
+// images of a change larger than maxBytes are dropped and the change is
+// flagged as truncated, a change the target rejects would block the stream.
+func encodeChanges(changes []ChangeEvent, maxBytes int) ([]encodedChange, error) {
+	encoded := make([]encodedChange, 0, len(changes))
+	for _, change := range changes {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal change event: %w", err)
+		}
+		if len(payload) > maxBytes {
+			change.Before, change.After, change.Truncated = nil, nil, true
+			if payload, err = json.Marshal(change); err != nil {
+				return nil, fmt.Errorf("failed to marshal change event: %w", err)
+			}
+		}
+		encoded = append(encoded, encodedChange{change: change, payload: payload})
+	}
+	return encoded, nil
+}
+
+// batches splits the changes in batches of at most maxCount changes and
+// maxBytes of payload, the limits of the target API
+func batches(encoded []encodedChange, maxCount int, maxBytes int) [][]encodedChange {
+	var result [][]encodedChange
+	var batch []encodedChange
+	size := 0
+	for _, change := range encoded {
+		if len(batch) == maxCount || (len(batch) > 0 && size+len(change.payload) > maxBytes) {
+			result = append(result, batch)
+			batch, size = nil, 0
+		}
+		batch = append(batch, change)
+		size += len(change.payload)
+	}
+	if len(batch) > 0 {
+		result = append(result, batch)
+	}
+	return result
+}
+
+type eventBridgePublisher struct {
+	client      *eventbridge.Client
+	eventBusARN string
+}
+
+func (p *eventBridgePublisher) Publish(ctx context.Context, changes []ChangeEvent) error {
+	encoded, err := encodeChanges(changes, maxEventBatchBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches(encoded, 10, maxEventBatchBytes) {
+		entries := make([]eventbridgetypes.PutEventsRequestEntry, 0, len(batch))
+		for _, encodedChange := range batch {
+			change := encodedChange.change
+			entries = append(entries, eventbridgetypes.PutEventsRequestEntry{
+				EventBusName: aws.String(p.eventBusARN),
+				Source:       aws.String(changeEventSource),
+				DetailType:   aws.String(fmt.Sprintf("%s.%s", change.Entity, change.Op)),
+				Detail:       aws.String(string(encodedChange.payload)),
+			})
+		}
+
+		output, err := p.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("failed to put change events: %w", err)
+		}
+		if output.FailedEntryCount > 0 {
+			return fmt.Errorf("failed to put %d change events", output.FailedEntryCount)
+		}
+	}
+	return nil
+}
+
+type snsPublisher struct {
+	client   *sns.Client
+	topicARN string
+}
+
+func (p *snsPublisher) Publish(ctx context.Context, changes []ChangeEvent) error {
+	fifo := strings.HasSuffix(p.topicARN, ".fifo")
+
+	encoded, err := encodeChanges(changes, maxEventBatchBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches(encoded, 10, maxEventBatchBytes) {
+		entries := make([]snstypes.PublishBatchRequestEntry, 0, len(batch))
+		for i, encodedChange := range batch {
+			change := encodedChange.change
+			entry := snstypes.PublishBatchRequestEntry{
+				Id:      aws.String(strconv.Itoa(i)),
+				Message: aws.String(string(encodedChange.payload)),
+				// Attributes let subscribers filter on entity and operation
+				MessageAttributes: map[string]snstypes.MessageAttributeValue{
+					"entity": {DataType: aws.String("String"), StringValue: aws.String(change.Entity)},
+					"op":     {DataType: aws.String("String"), StringValue: aws.String(change.Op)},
+				},
+			}
+			if fifo {
+				entry.MessageGroupId = aws.String(partitionKey(change))
+				entry.MessageDeduplicationId = aws.String(change.SequenceNumber)
+			}
+			entries = append(entries, entry)
+		}
+
+		output, err := p.client.PublishBatch(ctx, &sns.PublishBatchInput{
+			TopicArn:                   aws.String(p.topicARN),
+			PublishBatchRequestEntries: entries,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to publish change events: %w", err)
+		}
+		if len(output.Failed) > 0 {
+			return fmt.Errorf("failed to publish %d change events: %s", len(output.Failed), aws.ToString(output.Failed[0].Message))
+		}
+	}
+	return nil
+}
+
+type kinesisPublisher struct {
+	client    *kinesis.Client
+	streamARN string
+}
+
+func (p *kinesisPublisher) Publish(ctx context.Context, changes []ChangeEvent) error {
+	encoded, err := encodeChanges(changes, maxKinesisRecordBytes)
+	if err != nil {
+		return err
+	}
+
+	for _, batch := range batches(encoded, 500, maxKinesisBatchBytes) {
+		records := make([]kinesistypes.PutRecordsRequestEntry, 0, len(batch))
+		for _, encodedChange := range batch {
+			records = append(records, kinesistypes.PutRecordsRequestEntry{
+				Data:         encodedChange.payload,
+				PartitionKey: aws.String(partitionKey(encodedChange.change)),
+			})
+		}
+
+		output, err := p.client.PutRecords(ctx, &kinesis.PutRecordsInput{
+			StreamARN: aws.String(p.streamARN),
+			Records:   records,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put change records: %w", err)
+		}
+		if failed := aws.ToInt32(output.FailedRecordCount); failed > 0 {
+			return fmt.Errorf("failed to put %d change records", failed)
+		}
+	}
+	return nil
+}
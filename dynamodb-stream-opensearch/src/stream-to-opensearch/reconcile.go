@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	search "dynamodb-stream-opensearch-search"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
+)
+
+// The reconciliation job runs the stream processor binary with MODE=RECONCILE.
+// It scans the table and scrolls the indices, compares key sets and content
+// hashes and, with repair, fixes the drift through putDocument and
+// deleteFromIndex like the stream records do. Both sides are held in memory,
+// which suits the table sizes of this lab.
+
+const (
+	reconcileScrollSize      = 1000
+	reconcileScrollKeepAlive = time.Minute
+	// Drifted documents listed in the report, all of them are counted and repaired
+	reconcileReportLimit = 100
+)
+
+// ReconcileEvent is the input of the reconciliation job
+type ReconcileEvent struct {
+	Repair bool `json:"repair"`
+}
+
+// ReconcileReport lists the drift between the table and the indices as
+// "<index>/<document ID>" entries
+type ReconcileReport struct {
+	ScannedItems     int      `json:"scannedItems"`
+	IndexedDocuments int      `json:"indexedDocuments"`
+	MissingCount     int      `json:"missingCount"`
+	ExtraCount       int      `json:"extraCount"`
+	StaleCount       int      `json:"staleCount"`
+	Missing          []string `json:"missing"`
+	Extra            []string `json:"extra"`
+	Stale            []string `json:"stale"`
+	Repaired         int      `json:"repaired"`
+}
+
+// expectedDocument is the document the table item should be indexed as
+type expectedDocument struct {
+	index string
+	docID string
+	doc   search.Document
+	hash  string
+}
+
+func reconcileHandler(ctx context.Context, event ReconcileEvent) (*ReconcileReport, error) {
+	if err := initClients(ctx); err != nil {
+		return nil, err
+	}
+	osSink, ok := unwrapSink(sink).(*opensearchSink)
+	if !ok {
+		return nil, fmt.Errorf("reconciliation only supports the opensearch sink")
+	}
+
+	expected, scanned, err := scanTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{ScannedItems: scanned}
+	var extra []string
+
+	err = scrollDocuments(ctx, osSink.client, func(hit opensearchapi.SearchHit) error {
+		var doc search.Document
+		if err := json.Unmarshal(hit.Source, &doc); err != nil {
+			return fmt.Errorf("failed to unmarshal document %s: %w", hit.ID, err)
+		}
+		report.IndexedDocuments++
+
+		key := hit.Index + "/" + hit.ID
+		want, found := expected[key]
+		switch {
+		case !found && !doc.Deleted:
+			// Documents kept by the mark remove policy are expected to outlive their item
+			extra = append(extra, key)
+		case found && (doc.Deleted || contentHash(doc.Data) != want.hash):
+			report.StaleCount++
+			report.Stale = appendLimited(report.Stale, key)
+			delete(expected, key)
+			if event.Repair {
+				if err := putDocument(ctx, want.index, want.docID, &want.doc); err != nil {
+					return err
+				}
+				report.Repaired++
+			}
+		case found:
+			delete(expected, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Whatever was not matched by an indexed document is missing
+	missing := make([]string, 0, len(expected))
+	for key := range expected {
+		missing = append(missing, key)
+	}
+	sort.Strings(missing)
+
+	for _, key := range missing {
+		report.MissingCount++
+		report.Missing = appendLimited(report.Missing, key)
+		if event.Repair {
+			want := expected[key]
+			if err := putDocument(ctx, want.index, want.docID, &want.doc); err != nil {
+				return nil, err
+			}
+			report.Repaired++
+		}
+	}
+
+	for _, key := range extra {
+		report.ExtraCount++
+		report.Extra = appendLimited(report.Extra, key)
+		if event.Repair {
+			index, docID, _ := strings.Cut(key, "/")
+			if err := deleteFromIndex(ctx, index, docID); err != nil {
+				return nil, err
+			}
+			report.Repaired++
+		}
+	}
+
+	slog.InfoContext(ctx, "Reconciliation",
+		"scanned", report.ScannedItems,
+		"indexed", report.IndexedDocuments,
+		"missing", report.MissingCount,
+		"extra", report.ExtraCount,
+		"stale", report.StaleCount,
+		"repaired", report.Repaired,
+	)
+	return report, nil
+}
+
+// scanTable builds the expected documents of every table item, keyed by
+// "<index>/<document ID>"
+func scanTable(ctx context.Context) (map[string]expectedDocument, int, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	expected := make(map[string]expectedDocument)
+	scanned := 0
+	paginator := dynamodb.NewScanPaginator(dynamodb.NewFromConfig(cfg), &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan table: %w", err)
+		}
+
+		for _, item := range page.Items {
+			image := toStreamImage(item)
+			doc, docID := newDocument(image)
+			index := resolveIndex(image)
+			expected[index+"/"+docID] = expectedDocument{
+				index: index,
+				docID: docID,
+				doc:   doc,
+				hash:  contentHash(doc.Data),
+			}
+		}
+		scanned += len(page.Items)
+	}
+
+	slog.InfoContext(ctx, "Scanned table", "table", tableName, "items", scanned)
+	return expected, scanned, nil
+}
+
+// scrollDocuments calls fn for every document of the reconciled indices
+func scrollDocuments(ctx context.Context, client *opensearchapi.Client, fn func(opensearchapi.SearchHit) error) error {
+	resp, err := client.Search(ctx, &opensearchapi.SearchReq{
+		Indices: reconcileIndices,
+		Params: opensearchapi.SearchParams{
+			Scroll:            reconcileScrollKeepAlive,
+			Size:              opensearchapi.ToPointer(reconcileScrollSize),
+			AllowNoIndices:    opensearchapi.ToPointer(true),
+			IgnoreUnavailable: opensearchapi.ToPointer(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to search documents: %w", err)
+	}
+
+	hits, scrollID := resp.Hits.Hits, resp.ScrollID
+	defer func() {
+		if scrollID != nil {
+			client.Scroll.Delete(ctx, opensearchapi.ScrollDeleteReq{ScrollIDs: []string{*scrollID}})
+		}
+	}()
+
+	for len(hits) > 0 {
+		for _, hit := range hits {
+			if err := fn(hit); err != nil {
+				return err
+			}
+		}
+		if scrollID == nil {
+			return nil
+		}
+
+		page, err := client.Scroll.Get(ctx, opensearchapi.ScrollGetReq{
+			ScrollID: *scrollID,
+			Params:   opensearchapi.ScrollGetParams{Scroll: reconcileScrollKeepAlive},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scroll documents: %w", err)
+		}
+		hits, scrollID = page.Hits.Hits, page.ScrollID
+	}
+	return nil
+}
+
+// contentHash compares documents regardless of their timestamp, the JSON
+// encoding of maps is sorted by key so equal data gives equal hashes
+func contentHash(data map[string]interface{}) string {
+	raw, _ := json.Marshal(data)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func appendLimited(keys []string, key string) []string {
+	if len(keys) >= reconcileReportLimit {
+		return keys
+	}
+	return append(keys, key)
+}
+
+// toStreamImage converts a scanned item to the stream record image format
+// so it goes through the same document building code
+func toStreamImage(item map[string]types.AttributeValue) map[string]events.DynamoDBAttributeValue {
+	image := make(map[string]events.DynamoDBAttributeValue, len(item))
+	for key, value := range item {
+		image[key] = toStreamAttributeValue(value)
+	}
+	return image
+}
+
+func toStreamAttributeValue(av types.AttributeValue) events.DynamoDBAttributeValue {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return events.NewStringAttribute(v.Value)
+	case *types.AttributeValueMemberN:
+		return events.NewNumberAttribute(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return events.NewBooleanAttribute(v.Value)
+	case *types.AttributeValueMemberB:
+		return events.NewBinaryAttribute(v.Value)
+	case *types.AttributeValueMemberSS:
+		return events.NewStringSetAttribute(v.Value)
+	case *types.AttributeValueMemberNS:
+		return events.NewNumberSetAttribute(v.Value)
+	case *types.AttributeValueMemberBS:
+		return events.NewBinarySetAttribute(v.Value)
+	case *types.AttributeValueMemberL:
+		list := make([]events.DynamoDBAttributeValue, len(v.Value))
+		for i, item := range v.Value {
+			list[i] = toStreamAttributeValue(item)
+		}
+		return events.NewListAttribute(list)
+	case *types.AttributeValueMemberM:
+		return events.NewMapAttribute(toStreamImage(v.Value))
+	default:
+		return events.NewNullAttribute()
+	}
+}
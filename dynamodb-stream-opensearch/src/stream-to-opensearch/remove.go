@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	search "dynamodb-stream-opensearch-search"
+)
+
+// removePolicy defines what happens to the OpenSearch document when its
+// item is removed from the table
+type removePolicy string
+
+const (
+	// removePolicyDelete hard deletes the document
+	removePolicyDelete removePolicy = "delete"
+	// removePolicyMark keeps the document flagged with deleted=true
+	removePolicyMark removePolicy = "mark"
+	// removePolicyArchive moves the document to the archive index
+	removePolicyArchive removePolicy = "archive"
+)
+
+const (
+	removeCauseUser = "user"
+	removeCauseTTL  = "ttl"
+)
+
+func parseRemovePolicy(raw string) (removePolicy, error) {
+	switch policy := removePolicy(raw); policy {
+	case "":
+		return removePolicyDelete, nil
+	case removePolicyDelete, removePolicyMark, removePolicyArchive:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown remove policy %q, expected delete, mark or archive", raw)
+	}
+}
+
+// removeCause tells whether the item was removed by DynamoDB TTL or by a user
+// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/time-to-live-ttl-streams.html
+func removeCause(record changeRecord) string {
+	identity := record.UserIdentity
+	if identity != nil && identity.Type == "Service" && identity.PrincipalID == "dynamodb.amazonaws.com" {
+		return removeCauseTTL
+	}
+	return removeCauseUser
+}
+
+func deleteDocument(ctx context.Context, record changeRecord) error {
+	cause := removeCause(record)
+	policy := userRemovePolicy
+	if cause == removeCauseTTL {
+		policy = ttlRemovePolicy
+	}
+
+	doc, docID := newDocument(record.OldImage)
+	index := resolveIndex(record.OldImage)
+	slog.InfoContext(ctx, "Removing document", "doc_id", docID, "cause", cause, "policy", policy)
+
+	switch policy {
+	case removePolicyMark:
+		markDeleted(&doc, cause)
+		return putDocument(ctx, index, docID, &doc)
+	case removePolicyArchive:
+		markDeleted(&doc, cause)
+		if err := putDocument(ctx, archiveIndex, docID, &doc); err != nil {
+			return err
+		}
+		return deleteFromIndex(ctx, index, docID)
+	default:
+		return deleteFromIndex(ctx, index, docID)
+	}
+}
+
+func markDeleted(doc *search.Document, cause string) {
+	doc.Deleted = true
+	doc.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+	doc.DeleteCause = cause
+}
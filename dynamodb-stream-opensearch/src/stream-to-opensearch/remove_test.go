@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/opensearch-project/opensearch-go/v4"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
+)
+
+// useTestServer points the OpenSearch client to an httptest stand-in
+func useTestServer(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := opensearchapi.NewClient(opensearchapi.Config{
+		Client: opensearch.Config{Addresses: []string{server.URL}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create opensearch client: %v", err)
+	}
+
+	previous := sink
+	sink = &opensearchSink{client: client}
+	t.Cleanup(func() { sink = previous })
+}
+
+func removeRecord(pk string, sk string) changeRecord {
+	return changeRecord{
+		EventName: "REMOVE",
+		OldImage: map[string]events.DynamoDBAttributeValue{
+			"pk": events.NewStringAttribute(pk),
+			"sk": events.NewStringAttribute(sk),
+		},
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+	previousIndex := opensearchIndex
+	opensearchIndex = "items"
+	t.Cleanup(func() { opensearchIndex = previousIndex })
+
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr bool
+	}{
+		{
+			name:   "deleted",
+			status: http.StatusOK,
+			body:   `{"_index":"items","_id":"USER~231.PROFILE","_version":2,"result":"deleted"}`,
+		},
+		{
+			name:   "document never indexed",
+			status: http.StatusNotFound,
+			body:   `{"_index":"items","_id":"USER~231.PROFILE","_version":1,"result":"not_found"}`,
+		},
+		{
+			name:   "index not found",
+			status: http.StatusNotFound,
+			body:   `{"error":{"type":"index_not_found_exception","reason":"no such index [items]"},"status":404}`,
+		},
+		{
+			name:    "server error",
+			status:  http.StatusInternalServerError,
+			body:    `{"error":{"type":"exception","reason":"boom"},"status":500}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotMethod, gotPath string
+			useTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				gotMethod, gotPath = r.Method, r.URL.Path
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			err := deleteDocument(context.Background(), removeRecord("USER#1", "PROFILE"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deleteDocument() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotMethod != http.MethodDelete || gotPath != "/items/_doc/USER~231.PROFILE" {
+				t.Errorf("unexpected request %s %s", gotMethod, gotPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	search "dynamodb-stream-opensearch-search"
+
+	"github.com/opensearch-project/opensearch-go/v4"
+)
+
+// retryDeadlineMargin is the time kept for the last attempt before the
+// Lambda deadline, a backoff ending later is not worth waiting for
+const retryDeadlineMargin = time.Second
+
+var errCircuitOpen = errors.New("search sink circuit breaker is open")
+
+// retryPolicy is the exponential backoff with full jitter applied to
+// retryable sink errors, see isRetryable
+type retryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// parseRetryPolicy reads SEARCH_SINK_MAX_RETRIES, SEARCH_SINK_RETRY_BASE_DELAY
+// and SEARCH_SINK_RETRY_MAX_DELAY, defaulting to 3 retries from 200ms up to 5s
+func parseRetryPolicy() (retryPolicy, error) {
+	policy := retryPolicy{MaxRetries: 3, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+	var err error
+	if policy.MaxRetries, err = envInt("SEARCH_SINK_MAX_RETRIES", policy.MaxRetries); err != nil {
+		return policy, err
+	}
+	if policy.BaseDelay, err = envDuration("SEARCH_SINK_RETRY_BASE_DELAY", policy.BaseDelay); err != nil {
+		return policy, err
+	}
+	if policy.MaxDelay, err = envDuration("SEARCH_SINK_RETRY_MAX_DELAY", policy.MaxDelay); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 30 && p.BaseDelay<<attempt < p.MaxDelay {
+		delay = p.BaseDelay << attempt
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay) + 1
+}
+
+// circuitBreaker fails fast once Threshold consecutive calls failed with a
+// retryable error, e.g. while the cluster is red or rejecting writes. After
+// Cooldown a single call goes through, closing the breaker when it succeeds.
+// It lives in a global so the state is kept across warm invocations.
+type circuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// parseCircuitBreaker reads SEARCH_SINK_BREAKER_THRESHOLD (5 by default, 0
+// disables the breaker) and SEARCH_SINK_BREAKER_COOLDOWN (30s by default)
+func parseCircuitBreaker() (*circuitBreaker, error) {
+	threshold, err := envInt("SEARCH_SINK_BREAKER_THRESHOLD", 5)
+	if err != nil {
+		return nil, err
+	}
+	cooldown, err := envDuration("SEARCH_SINK_BREAKER_COOLDOWN", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &circuitBreaker{Threshold: threshold, Cooldown: cooldown}, nil
+}
+
+func (b *circuitBreaker) allow() bool {
+	if b.Threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	if b.Threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case err == nil:
+		if b.failures >= b.Threshold {
+			slog.InfoContext(ctx, "Search sink circuit breaker closed")
+		}
+		b.failures = 0
+	case isRetryable(err):
+		b.failures++
+		if b.failures >= b.Threshold {
+			b.openUntil = time.Now().Add(b.Cooldown)
+			slog.WarnContext(ctx, "Search sink circuit breaker open", "cooldown", b.Cooldown.String(), "failures", b.failures, "error", err)
+		}
+	}
+}
+
+// retryingSink decorates a SearchSink with the retry policy and the circuit breaker
+type retryingSink struct {
+	next    SearchSink
+	policy  retryPolicy
+	breaker *circuitBreaker
+}
+
+func newRetryingSink(next SearchSink, policy retryPolicy, breaker *circuitBreaker) *retryingSink {
+	return &retryingSink{next: next, policy: policy, breaker: breaker}
+}
+
+// unwrapSink returns the sink decorated by the retrying sink
+func unwrapSink(s SearchSink) SearchSink {
+	if retrying, ok := s.(*retryingSink); ok {
+		return retrying.next
+	}
+	return s
+}
+
+func (s *retryingSink) Index(ctx context.Context, index string, docID string, doc *search.Document) error {
+	return s.do(ctx, func() error {
+		return s.next.Index(ctx, index, docID, doc)
+	})
+}
+
+func (s *retryingSink) Update(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error {
+	return s.do(ctx, func() error {
+		return s.next.Update(ctx, index, docID, changed, doc)
+	})
+}
+
+func (s *retryingSink) Delete(ctx context.Context, index string, docID string) (string, error) {
+	var result string
+	err := s.do(ctx, func() error {
+		var err error
+		result, err = s.next.Delete(ctx, index, docID)
+		return err
+	})
+	return result, err
+}
+
+func (s *retryingSink) do(ctx context.Context, call func() error) error {
+	if !s.breaker.allow() {
+		return errCircuitOpen
+	}
+
+	err := call()
+	for attempt := 0; err != nil && isRetryable(err) && attempt < s.policy.MaxRetries; attempt++ {
+		delay := s.policy.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay+retryDeadlineMargin {
+			slog.WarnContext(ctx, "Not retrying, the invocation deadline is too close", "error", err)
+			break
+		}
+		slog.WarnContext(ctx, "Retrying", "delay", delay.String(), "attempt", attempt+1, "max_retries", s.policy.MaxRetries, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.breaker.record(ctx, err)
+			return fmt.Errorf("%w while retrying: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+		err = call()
+	}
+
+	s.breaker.record(ctx, err)
+	return err
+}
+
+// isRetryable reports whether the error is transient: throttling (429),
+// unavailable cluster or gateway (502, 503, 504) and network errors
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	switch errorStatusCode(err) {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case 0:
+		var netErr net.Error
+		return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	default:
+		return false
+	}
+}
+
+// errorStatusCode returns the HTTP status carried by the sink error, 0 when unknown
+func errorStatusCode(err error) int {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	var responseErr *opensearchResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode
+	}
+	var structErr *opensearch.StructError
+	if errors.As(err, &structErr) {
+		return structErr.Status
+	}
+	var stringErr *opensearch.StringError
+	if errors.As(err, &stringErr) {
+		return stringErr.Status
+	}
+	return 0
+}
+
+func envInt(name string, fallback int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive integer", name, raw)
+	}
+	return value, nil
+}
+
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a duration, e.g. 500ms", name, raw)
+	}
+	return value, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// routingRule maps a DynamoDB item to an OpenSearch index.
+// A rule matches when the partition key starts with PKPrefix (if set) and
+// the Attribute is present on the item, equal to Value when Value is set
+// (string attributes, or number attributes of the same numeric value).
+// Index is a template: "{name}" is replaced by the string value of the item
+// attribute "name" and "{name:layout}" formats a date attribute (RFC3339
+// string or epoch seconds number) with the Go time layout, e.g.
+// "events-{createdAt:2006.01}". A rule rendering an invalid index name does
+// not match, the record falls back to the next rules or the default index.
+type routingRule struct {
+	PKPrefix  string `json:"pkPrefix"`
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
+	Index     string `json:"index"`
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{([^{}:]+)(?::([^{}]+))?\}`)
+
+// parseRoutingRules reads the routing rules JSON array, an empty value means
+// every record goes to the default index.
+func parseRoutingRules(raw string) ([]routingRule, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var rules []routingRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse routing rules: %w", err)
+	}
+
+	for i, rule := range rules {
+		if rule.Index == "" {
+			return nil, fmt.Errorf("routing rule %d has no index", i)
+		}
+		if rule.PKPrefix == "" && rule.Attribute == "" {
+			return nil, fmt.Errorf("routing rule %d needs a pkPrefix or an attribute", i)
+		}
+	}
+	return rules, nil
+}
+
+// resolveIndex returns the index of the first matching rule for the item
+// image, or the default index when no rule matches. The same image must be
+// used for indexing (NewImage) and deleting (OldImage) so both paths agree.
+func resolveIndex(image map[string]events.DynamoDBAttributeValue) string {
+	pk := getStringValue(image["pk"])
+
+	for _, rule := range routingRules {
+		if rule.PKPrefix != "" && !strings.HasPrefix(pk, rule.PKPrefix) {
+			continue
+		}
+		if rule.Attribute != "" {
+			value, ok := image[rule.Attribute]
+			if !ok || value.IsNull() {
+				continue
+			}
+			if rule.Value != "" && !attributeEquals(value, rule.Value) {
+				continue
+			}
+		}
+
+		index, ok := renderIndexTemplate(rule.Index, image)
+		if !ok || !validIndexName(index) {
+			// the template references an attribute the item does not have, or
+			// an attribute value OpenSearch rejects in an index name
+			continue
+		}
+		return index
+	}
+
+	return opensearchIndex
+}
+
+func renderIndexTemplate(template string, image map[string]events.DynamoDBAttributeValue) (string, bool) {
+	ok := true
+	index := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		groups := templatePlaceholder.FindStringSubmatch(placeholder)
+		name, layout := groups[1], groups[2]
+
+		value, found := image[name]
+		if !found {
+			ok = false
+			return ""
+		}
+
+		if layout != "" {
+			t, isTime := attributeTime(value)
+			if !isTime {
+				ok = false
+				return ""
+			}
+			return t.UTC().Format(layout)
+		}
+
+		s := getStringValue(value)
+		if s == "" && value.DataType() == events.DataTypeNumber {
+			s = value.Number()
+		}
+		if s == "" {
+			ok = false
+		}
+		return s
+	})
+
+	// OpenSearch index names must be lowercase
+	return strings.ToLower(index), ok && index != ""
+}
+
+// attributeEquals compares a string or number attribute to a rule value,
+// numbers by value so "1.50" matches 1.5
+func attributeEquals(av events.DynamoDBAttributeValue, want string) bool {
+	switch av.DataType() {
+	case events.DataTypeString:
+		return av.String() == want
+	case events.DataTypeNumber:
+		if av.Number() == want {
+			return true
+		}
+		number, err := strconv.ParseFloat(av.Number(), 64)
+		if err != nil {
+			return false
+		}
+		wanted, err := strconv.ParseFloat(want, 64)
+		return err == nil && number == wanted
+	default:
+		return false
+	}
+}
+
+// maxIndexNameBytes is the OpenSearch limit on index names
+const maxIndexNameBytes = 255
+
+// validIndexName applies the OpenSearch index naming restrictions to a
+// rendered (lowercase) index name
+func validIndexName(index string) bool {
+	switch {
+	case index == "" || index == "." || index == "..":
+		return false
+	case len(index) > maxIndexNameBytes:
+		return false
+	case strings.ContainsAny(index[:1], "_-+"):
+		return false
+	}
+	return !strings.ContainsAny(index, ` "*\<|,>/?#:`)
+}
+
+// attributeTime reads a date stored either as an RFC3339 string or as epoch
+// seconds, the format used by DynamoDB TTL attributes.
+func attributeTime(av events.DynamoDBAttributeValue) (time.Time, bool) {
+	switch av.DataType() {
+	case events.DataTypeString:
+		t, err := time.Parse(time.RFC3339, av.String())
+		return t, err == nil
+	case events.DataTypeNumber:
+		seconds, err := strconv.ParseInt(av.Number(), 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(seconds, 0), true
+	default:
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestResolveIndex(t *testing.T) {
+	previousIndex, previousRules := opensearchIndex, routingRules
+	t.Cleanup(func() { opensearchIndex, routingRules = previousIndex, previousRules })
+	opensearchIndex = "items"
+	routingRules = []routingRule{
+		{Attribute: "tier", Value: "1.5", Index: "tier-one"},
+		{PKPrefix: "USER#", Attribute: "team", Index: "team-{team}"},
+		{Attribute: "entityType", Index: "{entityType}s"},
+	}
+
+	tests := []struct {
+		name  string
+		image map[string]events.DynamoDBAttributeValue
+		want  string
+	}{
+		{
+			name:  "no matching rule",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("ORDER#1")},
+			want:  "items",
+		},
+		{
+			name:  "string value",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("X#1"), "tier": events.NewStringAttribute("1.5")},
+			want:  "tier-one",
+		},
+		{
+			name:  "number value",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("X#1"), "tier": events.NewNumberAttribute("1.50")},
+			want:  "tier-one",
+		},
+		{
+			name:  "different number value",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("X#1"), "tier": events.NewNumberAttribute("2")},
+			want:  "items",
+		},
+		{
+			name:  "template lowercased",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("USER#1"), "team": events.NewStringAttribute("Core")},
+			want:  "team-core",
+		},
+		{
+			name: "invalid name falls back to the next rule",
+			image: map[string]events.DynamoDBAttributeValue{
+				"pk":         events.NewStringAttribute("USER#1"),
+				"team":       events.NewStringAttribute("a b"),
+				"entityType": events.NewStringAttribute("user"),
+			},
+			want: "users",
+		},
+		{
+			name:  "invalid name falls back to the default index",
+			image: map[string]events.DynamoDBAttributeValue{"pk": events.NewStringAttribute("X#1"), "entityType": events.NewStringAttribute("USER#1")},
+			want:  "items",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveIndex(tt.image); got != tt.want {
+				t.Errorf("resolveIndex() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidIndexName(t *testing.T) {
+	tests := []struct {
+		index string
+		want  bool
+	}{
+		{index: "events-2024.01", want: true},
+		{index: "user#1"},
+		{index: "a b"},
+		{index: "x/y"},
+		{index: "_x"},
+		{index: "-x"},
+		{index: "a*b"},
+		{index: "a,b"},
+		{index: `a"b`},
+		{index: "a|b"},
+		{index: `a\b`},
+		{index: ".."},
+		{index: strings.Repeat("a", 256)},
+	}
+	for _, tt := range tests {
+		if got := validIndexName(tt.index); got != tt.want {
+			t.Errorf("validIndexName(%q) = %v, want %v", tt.index, got, tt.want)
+		}
+	}
+}
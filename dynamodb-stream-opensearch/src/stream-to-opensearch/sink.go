@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	search "dynamodb-stream-opensearch-search"
+)
+
+// SearchSink is a search engine the stream records are projected into.
+// Implementations must treat deleting a missing document as a success.
+type SearchSink interface {
+	// Index creates or replaces the document
+	Index(ctx context.Context, index string, docID string, doc *search.Document) error
+	// Update merges the changed data fields into the document, doc is the
+	// full document to create when it does not exist yet
+	Update(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error
+	// Delete removes the document and returns the outcome, e.g. "deleted" or "not_found"
+	Delete(ctx context.Context, index string, docID string) (string, error)
+}
+
+// versionTypeExternalGTE accepts a write with the version of the indexed
+// document, a record delivered twice is written again
+const versionTypeExternalGTE = "external_gte"
+
+const (
+	sinkOpenSearch    = "opensearch"
+	sinkElasticsearch = "elasticsearch"
+	sinkMeilisearch   = "meilisearch"
+)
+
+// newSearchSink creates the sink selected by SEARCH_SINK, OpenSearch by default
+func newSearchSink(ctx context.Context, kind string) (SearchSink, error) {
+	switch kind {
+	case "", sinkOpenSearch:
+		return newOpenSearchSink(ctx)
+	case sinkElasticsearch:
+		return newElasticsearchSink()
+	case sinkMeilisearch:
+		return newMeilisearchSink()
+	default:
+		return nil, fmt.Errorf("unknown search sink %q, expected opensearch, elasticsearch or meilisearch", kind)
+	}
+}
+
+// partialUpdateBody is the _update body shared by OpenSearch and Elasticsearch
+func partialUpdateBody(changed map[string]interface{}, doc *search.Document) map[string]interface{} {
+	return map[string]interface{}{
+		"doc": map[string]interface{}{
+			"data":      changed,
+			"timestamp": doc.Timestamp,
+		},
+		"upsert": doc,
+	}
+}
+
+// httpStatusError is returned by doJSON for non 2xx responses
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// doJSON sends a JSON request for the sinks talking plain HTTP
+func doJSON(ctx context.Context, client *http.Client, method string, url string, body interface{}, header http.Header) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &httpStatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	search "dynamodb-stream-opensearch-search"
+)
+
+// elasticsearchSink writes to a self-managed Elasticsearch cluster through
+// its REST API, authenticated with an API key when SEARCH_SINK_API_KEY is set
+type elasticsearchSink struct {
+	baseURL string
+	header  http.Header
+	client  *http.Client
+}
+
+func newElasticsearchSink() (*elasticsearchSink, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("SEARCH_SINK_URL"), "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("SEARCH_SINK_URL environment variable is required by the elasticsearch sink")
+	}
+
+	header := http.Header{}
+	if apiKey := os.Getenv("SEARCH_SINK_API_KEY"); apiKey != "" {
+		header.Set("Authorization", "ApiKey "+apiKey)
+	}
+
+	return &elasticsearchSink{baseURL: baseURL, header: header, client: http.DefaultClient}, nil
+}
+
+func (s *elasticsearchSink) documentURL(index string, endpoint string, docID string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", s.baseURL, url.PathEscape(index), endpoint, url.PathEscape(docID))
+}
+
+// versionedURL adds the external version of the record being processed, if any
+func versionedURL(ctx context.Context, documentURL string) string {
+	version, ok := externalVersion(ctx)
+	if !ok {
+		return documentURL
+	}
+	return fmt.Sprintf("%s?version=%d&version_type=%s", documentURL, version, versionTypeExternalGTE)
+}
+
+func (s *elasticsearchSink) Index(ctx context.Context, index string, docID string, doc *search.Document) error {
+	return doJSON(ctx, s.client, http.MethodPut, versionedURL(ctx, s.documentURL(index, "_doc", docID)), doc, s.header)
+}
+
+func (s *elasticsearchSink) Update(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error {
+	return doJSON(ctx, s.client, http.MethodPost, s.documentURL(index, "_update", docID), partialUpdateBody(changed, doc), s.header)
+}
+
+func (s *elasticsearchSink) Delete(ctx context.Context, index string, docID string) (string, error) {
+	err := doJSON(ctx, s.client, http.MethodDelete, versionedURL(ctx, s.documentURL(index, "_doc", docID)), nil, s.header)
+
+	// A missing document or index both answer 404
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return "not_found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "deleted", nil
+}
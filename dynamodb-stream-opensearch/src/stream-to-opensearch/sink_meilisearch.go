@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	search "dynamodb-stream-opensearch-search"
+)
+
+// meilisearchSink writes to a Meilisearch instance. Meilisearch processes
+// writes asynchronously as tasks, a success only means the task was enqueued.
+type meilisearchSink struct {
+	baseURL string
+	header  http.Header
+	client  *http.Client
+}
+
+// meilisearchDocument adds the primary key Meilisearch requires on every document
+type meilisearchDocument struct {
+	ID string `json:"id"`
+	*search.Document
+}
+
+// Meilisearch document IDs only allow [A-Za-z0-9_-] and 511 bytes, the
+// characters of our IDs outside that set are escaped with an underscore and
+// too long IDs are hashed behind the "_h" prefix no escaped ID can start with
+var meilisearchIDReplacer = strings.NewReplacer("_", "_0", "~", "_1", ".", "_2")
+
+const meilisearchMaxIDLength = 511
+
+func newMeilisearchSink() (*meilisearchSink, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("SEARCH_SINK_URL"), "/")
+	if baseURL == "" {
+		return nil, fmt.Errorf("SEARCH_SINK_URL environment variable is required by the meilisearch sink")
+	}
+
+	header := http.Header{}
+	if apiKey := os.Getenv("SEARCH_SINK_API_KEY"); apiKey != "" {
+		header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	return &meilisearchSink{baseURL: baseURL, header: header, client: http.DefaultClient}, nil
+}
+
+func meilisearchDocID(docID string) string {
+	id := meilisearchIDReplacer.Replace(docID)
+	if len(id) > meilisearchMaxIDLength {
+		sum := sha256.Sum256([]byte(docID))
+		return "_h" + hex.EncodeToString(sum[:])
+	}
+	return id
+}
+
+func (s *meilisearchSink) documentsURL(index string) string {
+	return fmt.Sprintf("%s/indexes/%s/documents", s.baseURL, url.PathEscape(index))
+}
+
+func (s *meilisearchSink) Index(ctx context.Context, index string, docID string, doc *search.Document) error {
+	documents := []meilisearchDocument{{ID: meilisearchDocID(docID), Document: doc}}
+	return doJSON(ctx, s.client, http.MethodPost, s.documentsURL(index)+"?primaryKey=id", documents, s.header)
+}
+
+// Update replaces the whole document, Meilisearch partial updates only merge
+// top level fields and would drop the unchanged data fields
+func (s *meilisearchSink) Update(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error {
+	return s.Index(ctx, index, docID, doc)
+}
+
+// Delete enqueues the deletion, Meilisearch does not fail on missing documents
+func (s *meilisearchSink) Delete(ctx context.Context, index string, docID string) (string, error) {
+	err := doJSON(ctx, s.client, http.MethodDelete, s.documentsURL(index)+"/"+meilisearchDocID(docID), nil, s.header)
+	if err != nil {
+		return "", err
+	}
+	return "enqueued", nil
+}
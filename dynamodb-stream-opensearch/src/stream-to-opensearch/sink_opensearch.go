@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	search "dynamodb-stream-opensearch-search"
+
+	"github.com/opensearch-project/opensearch-go/v4"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchapi"
+	"github.com/opensearch-project/opensearch-go/v4/opensearchutil"
+)
+
+// opensearchSink writes to an OpenSearch cluster, by default an Amazon
+// OpenSearch Service domain with SigV4 signed requests
+type opensearchSink struct {
+	client *opensearchapi.Client
+}
+
+func newOpenSearchSink(ctx context.Context) (*opensearchSink, error) {
+	// The client retries are disabled, retryingSink retries with backoff instead
+	client, err := search.NewOpenSearchClient(ctx, opensearchEndpoint, func(config *opensearch.Config) {
+		config.DisableRetry = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &opensearchSink{client: client}, nil
+}
+
+func (s *opensearchSink) Index(ctx context.Context, index string, docID string, doc *search.Document) error {
+	resp, err := s.client.Index(
+		ctx,
+		opensearchapi.IndexReq{
+			Index:      index,
+			DocumentID: docID,
+			Body:       opensearchutil.NewJSONReader(doc),
+			Params:     opensearchapi.IndexParams{Version: versionParam(ctx), VersionType: versionTypeParam(ctx)},
+		},
+	)
+	return withResponseStatus(resp, err)
+}
+
+func (s *opensearchSink) Update(ctx context.Context, index string, docID string, changed map[string]interface{}, doc *search.Document) error {
+	resp, err := s.client.Update(
+		ctx,
+		opensearchapi.UpdateReq{
+			Index:      index,
+			DocumentID: docID,
+			Body:       opensearchutil.NewJSONReader(partialUpdateBody(changed, doc)),
+		},
+	)
+	return withResponseStatus(resp, err)
+}
+
+func (s *opensearchSink) Delete(ctx context.Context, index string, docID string) (string, error) {
+	resp, err := s.client.Document.Delete(
+		ctx,
+		opensearchapi.DocumentDeleteReq{
+			Index:      index,
+			DocumentID: docID,
+			Params:     opensearchapi.DocumentDeleteParams{Version: versionParam(ctx), VersionType: versionTypeParam(ctx)},
+		},
+	)
+	if err != nil {
+		// Deletes are idempotent, a document never indexed ("result": "not_found")
+		// and a missing index (index_not_found_exception) are both a success
+		if isNotFound(resp, err) {
+			return "not_found", nil
+		}
+		return "", withResponseStatus(resp, err)
+	}
+	return resp.Result, nil
+}
+
+// versionParam and versionTypeParam set the external version of the record
+// being processed, if any
+func versionParam(ctx context.Context) *int {
+	version, ok := externalVersion(ctx)
+	if !ok {
+		return nil
+	}
+	v := int(version)
+	return &v
+}
+
+func versionTypeParam(ctx context.Context) string {
+	if _, ok := externalVersion(ctx); !ok {
+		return ""
+	}
+	return versionTypeExternalGTE
+}
+
+func isNotFound(resp *opensearchapi.DocumentDeleteResp, err error) bool {
+	if resp != nil && resp.Inspect().Response != nil {
+		return resp.Inspect().Response.StatusCode == http.StatusNotFound
+	}
+
+	var opensearchErr *opensearch.StructError
+	return errors.As(err, &opensearchErr) && opensearchErr.Status == http.StatusNotFound
+}
+
+// opensearchResponseError keeps the HTTP status of a failed response, the
+// body is not always an OpenSearch JSON error, e.g. a 503 from the load balancer
+type opensearchResponseError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *opensearchResponseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *opensearchResponseError) Unwrap() error {
+	return e.Err
+}
+
+func withResponseStatus[R interface{ Inspect() opensearchapi.Inspect }](resp *R, err error) error {
+	if err == nil || resp == nil || (*resp).Inspect().Response == nil {
+		return err
+	}
+	return &opensearchResponseError{StatusCode: (*resp).Inspect().Response.StatusCode, Err: err}
+}
@@ -60,23 +60,126 @@ resource "aws_iam_role_policy" "lambda_stream_processor_policy" {
   })
 }
 
+# DynamoDB Stream Processor IAM Policy for OpenSearch basic auth credentials
+resource "aws_iam_role_policy" "lambda_stream_processor_secret_policy" {
+  count = var.opensearch_credentials_secret_arn == "" ? 0 : 1
+
+  name = "${var.resource_prefix}-stream-processor-secret-policy"
+  role = aws_iam_role.lambda_stream_processor_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "secretsmanager:GetSecretValue"
+        Resource = var.opensearch_credentials_secret_arn
+      }
+    ]
+  })
+}
+
+# DynamoDB Stream Processor IAM Policy for the change events publisher
+locals {
+  change_publisher_actions = {
+    eventbridge = "events:PutEvents"
+    sns         = "sns:Publish"
+    kinesis     = "kinesis:PutRecords"
+  }
+}
+
+resource "aws_iam_role_policy" "lambda_stream_processor_publisher_policy" {
+  count = var.change_publisher == "" ? 0 : 1
+
+  name = "${var.resource_prefix}-stream-processor-publisher-policy"
+  role = aws_iam_role.lambda_stream_processor_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = local.change_publisher_actions[var.change_publisher]
+        Resource = var.change_publisher_target_arn
+      }
+    ]
+  })
+}
+
+# Batches failing after the retries are bisected down to the failing record,
+# whose stream position is sent to this queue and the shard moves on
+resource "aws_sqs_queue" "stream_failures" {
+  name                      = "${var.resource_prefix}-stream-failures"
+  message_retention_seconds = 1209600
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+resource "aws_iam_role_policy" "lambda_stream_processor_failures_policy" {
+  name = "${var.resource_prefix}-stream-processor-failures-policy"
+  role = aws_iam_role.lambda_stream_processor_role.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = "sqs:SendMessage"
+        Resource = aws_sqs_queue.stream_failures.arn
+      }
+    ]
+  })
+}
+
 # DynamoDB Stream Processor Build and Packaging
 resource "null_resource" "lambda_stream_processor_build" {
   triggers = {
-    source_hash = filemd5("${path.module}/src/stream-to-opensearch/main.go")
+    source_hash = sha1(join("", concat(
+      [for f in sort(setunion(fileset("${path.module}/src", "stream-to-opensearch/*.go"), fileset("${path.module}/src", "search/*.go"))) : filemd5("${path.module}/src/${f}")],
+      [filemd5("${path.module}/../shared/lambdalog/lambdalog.go")],
+    )))
   }
 
   provisioner "local-exec" {
     command = <<EOT
       cd ${path.module}/src/stream-to-opensearch
       go mod tidy
-      GOOS=linux GOARCH=amd64 go build -o ../../dist/stream-to-opensearch/bootstrap main.go
+      GOOS=linux GOARCH=amd64 go build -o ../../dist/stream-to-opensearch/bootstrap .
       cd ../../dist/stream-to-opensearch
       zip bootstrap.zip bootstrap
     EOT
   }
 }
 
+# DynamoDB Stream Processor environment, shared with the reconciliation job
+locals {
+  stream_processor_environment = {
+    TABLE_NAME                    = aws_dynamodb_table.main.name
+    OPENSEARCH_ENDPOINT           = aws_opensearch_domain.main.endpoint
+    OPENSEARCH_INDEX              = "dynamodb-items"
+    OPENSEARCH_ROUTING_RULES      = jsonencode(var.opensearch_routing_rules)
+    OPENSEARCH_ARCHIVE_INDEX      = "dynamodb-items-archive"
+    OPENSEARCH_INDEXED_FIELDS     = join(",", var.opensearch_indexed_fields)
+    OPENSEARCH_PARTIAL_UPDATES    = tostring(var.opensearch_partial_updates)
+    REMOVE_POLICY                 = var.remove_policy
+    TTL_REMOVE_POLICY             = var.ttl_remove_policy
+    OPENSEARCH_SIGNING            = var.opensearch_signing
+    OPENSEARCH_CREDENTIALS_SECRET = var.opensearch_credentials_secret_arn
+    SEARCH_SINK                   = var.search_sink
+    SEARCH_SINK_URL               = var.search_sink_url
+    SEARCH_SINK_API_KEY           = var.search_sink_api_key
+    SEARCH_SINK_MAX_RETRIES       = tostring(var.search_sink_max_retries)
+    SEARCH_SINK_BREAKER_THRESHOLD = tostring(var.search_sink_breaker_threshold)
+    SEARCH_SINK_BREAKER_COOLDOWN  = var.search_sink_breaker_cooldown
+    CHANGE_PUBLISHER              = var.change_publisher
+    CHANGE_PUBLISHER_TARGET_ARN   = var.change_publisher_target_arn
+    METRICS_NAMESPACE             = var.metrics_namespace
+    STREAM_LAG_WARN_THRESHOLD     = var.stream_lag_warn_threshold
+  }
+}
+
 # DynamoDB Stream Processor Lambda Function
 resource "aws_lambda_function" "lambda_stream_processor" {
   filename      = "${path.module}/dist/stream-to-opensearch/bootstrap.zip"
@@ -88,11 +191,7 @@ resource "aws_lambda_function" "lambda_stream_processor" {
   timeout       = 900
 
   environment {
-    variables = {
-      TABLE_NAME          = aws_dynamodb_table.main.name
-      OPENSEARCH_ENDPOINT = aws_opensearch_domain.main.endpoint
-      OPENSEARCH_INDEX    = "dynamodb-items"
-    }
+    variables = local.stream_processor_environment
   }
 
   depends_on = [null_resource.lambda_stream_processor_build]
@@ -110,11 +209,22 @@ resource "aws_cloudwatch_log_group" "lambda_logs" {
 
 # DynamoDB Stream -> Stream Processor Lambda
 resource "aws_lambda_event_source_mapping" "dynamodb_stream" {
+  count = var.stream_source == "dynamodb" ? 1 : 0
+
   event_source_arn  = aws_dynamodb_table.main.stream_arn
   function_name     = aws_lambda_function.lambda_stream_processor.arn
   starting_position = "LATEST"
   batch_size        = 100
 
+  maximum_retry_attempts         = var.stream_maximum_retry_attempts
+  bisect_batch_on_function_error = true
+
+  destination_config {
+    on_failure {
+      destination_arn = aws_sqs_queue.stream_failures.arn
+    }
+  }
+
   filter_criteria {
     filter {
       pattern = jsonencode({
@@ -126,4 +236,62 @@ resource "aws_lambda_event_source_mapping" "dynamodb_stream" {
   tags = {
     Application = "${var.resource_prefix}"
   }
+
+  depends_on = [aws_iam_role_policy.lambda_stream_processor_failures_policy]
+}
+
+# The mapping got a count with the Kinesis source, keep the deployed one
+# instead of recreating it and losing the changes made in between
+moved {
+  from = aws_lambda_event_source_mapping.dynamodb_stream
+  to   = aws_lambda_event_source_mapping.dynamodb_stream[0]
+}
+
+# Search staleness alarm on the StreamLagMax EMF metric
+resource "aws_cloudwatch_metric_alarm" "stream_lag" {
+  count = var.stream_lag_alarm_threshold_ms > 0 ? 1 : 0
+
+  alarm_name          = "${var.resource_prefix}-stream-lag"
+  alarm_description   = "The OpenSearch index is lagging behind the DynamoDB table"
+  namespace           = var.metrics_namespace
+  metric_name         = "StreamLagMax"
+  statistic           = "Maximum"
+  period              = 60
+  evaluation_periods  = 5
+  threshold           = var.stream_lag_alarm_threshold_ms
+  comparison_operator = "GreaterThanThreshold"
+  treat_missing_data  = "notBreaching"
+
+  dimensions = {
+    FunctionName = aws_lambda_function.lambda_stream_processor.function_name
+  }
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
+}
+
+# The Lambda IteratorAge metric is emitted by the event source mapping for
+# every invocation, failed ones included, independently of the function code
+resource "aws_cloudwatch_metric_alarm" "iterator_age" {
+  count = var.stream_lag_alarm_threshold_ms > 0 ? 1 : 0
+
+  alarm_name          = "${var.resource_prefix}-iterator-age"
+  alarm_description   = "The stream processor is not keeping up with the DynamoDB changes"
+  namespace           = "AWS/Lambda"
+  metric_name         = "IteratorAge"
+  statistic           = "Maximum"
+  period              = 60
+  evaluation_periods  = 5
+  threshold           = var.stream_lag_alarm_threshold_ms
+  comparison_operator = "GreaterThanThreshold"
+  treat_missing_data  = "notBreaching"
+
+  dimensions = {
+    FunctionName = aws_lambda_function.lambda_stream_processor.function_name
+  }
+
+  tags = {
+    Application = "${var.resource_prefix}"
+  }
 }
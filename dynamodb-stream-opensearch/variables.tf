@@ -14,3 +14,183 @@ variable "opensearch_access_ip" {
   description = "IP address allowed to access OpenSearch domain"
   sensitive   = true
 }
+
+variable "opensearch_routing_rules" {
+  type = list(object({
+    pkPrefix  = optional(string, "")
+    attribute = optional(string, "")
+    value     = optional(string, "")
+    index     = string
+  }))
+  description = "Rules routing items to OpenSearch indices by pk prefix or attribute, index supports {attribute} and {attribute:layout} placeholders"
+  default     = []
+}
+
+variable "remove_policy" {
+  type        = string
+  description = "What to do with the OpenSearch document when an item is deleted by a user: delete, mark or archive"
+  default     = "delete"
+
+  validation {
+    condition     = contains(["delete", "mark", "archive"], var.remove_policy)
+    error_message = "remove_policy must be one of delete, mark or archive."
+  }
+}
+
+variable "ttl_remove_policy" {
+  type        = string
+  description = "What to do with the OpenSearch document when an item expires through DynamoDB TTL: delete, mark or archive"
+  default     = "delete"
+
+  validation {
+    condition     = contains(["delete", "mark", "archive"], var.ttl_remove_policy)
+    error_message = "ttl_remove_policy must be one of delete, mark or archive."
+  }
+}
+
+variable "opensearch_indexed_fields" {
+  type        = list(string)
+  description = "Item attributes copied to the OpenSearch document, all attributes when empty. MODIFY records changing none of them are skipped"
+  default     = []
+}
+
+variable "opensearch_partial_updates" {
+  type        = bool
+  description = "Send MODIFY records as partial _update requests with only the changed fields instead of reindexing the document"
+  default     = false
+}
+
+variable "search_sink" {
+  type        = string
+  description = "Search engine the stream processor writes to: opensearch (the lab domain), elasticsearch or meilisearch"
+  default     = "opensearch"
+
+  validation {
+    condition     = contains(["opensearch", "elasticsearch", "meilisearch"], var.search_sink)
+    error_message = "search_sink must be one of opensearch, elasticsearch or meilisearch."
+  }
+}
+
+variable "search_sink_url" {
+  type        = string
+  description = "Base URL of the self-managed elasticsearch or meilisearch sink"
+  default     = ""
+}
+
+variable "search_sink_api_key" {
+  type        = string
+  description = "API key of the self-managed elasticsearch or meilisearch sink"
+  default     = ""
+  sensitive   = true
+}
+
+variable "search_sink_max_retries" {
+  type        = number
+  description = "Retries with exponential backoff of throttled (429) or unavailable (502, 503, 504) search sink calls"
+  default     = 3
+}
+
+variable "search_sink_breaker_threshold" {
+  type        = number
+  description = "Consecutive failed search sink calls opening the circuit breaker, 0 disables it"
+  default     = 5
+}
+
+variable "search_sink_breaker_cooldown" {
+  type        = string
+  description = "Time the open circuit breaker fails fast before letting a call through, as a Go duration"
+  default     = "30s"
+}
+
+variable "opensearch_signing" {
+  type        = string
+  description = "Request signing of the OpenSearch client: sigv4 for the lab domain, none for basic auth only or a local cluster"
+  default     = "sigv4"
+
+  validation {
+    condition     = contains(["sigv4", "none"], var.opensearch_signing)
+    error_message = "opensearch_signing must be one of sigv4 or none."
+  }
+}
+
+variable "opensearch_credentials_secret_arn" {
+  type        = string
+  description = "Secrets Manager secret holding {\"username\", \"password\"} basic auth credentials of the OpenSearch fine-grained access control"
+  default     = ""
+}
+
+variable "change_publisher" {
+  type        = string
+  description = "Publish normalized change events to eventbridge, sns or kinesis in addition to indexing, disabled when empty"
+  default     = ""
+
+  validation {
+    condition     = contains(["", "eventbridge", "sns", "kinesis"], var.change_publisher)
+    error_message = "change_publisher must be empty or one of eventbridge, sns or kinesis."
+  }
+}
+
+variable "change_publisher_target_arn" {
+  type        = string
+  description = "ARN of the EventBridge event bus, SNS topic or Kinesis stream receiving the change events"
+  default     = ""
+}
+
+variable "search_api_indices" {
+  type        = string
+  description = "Comma separated indices or patterns searched by the search API and the reconciliation job, should cover the routing rules indices"
+  default     = "dynamodb-items"
+}
+
+variable "reconcile_schedule" {
+  type        = string
+  description = "EventBridge schedule expression of the reconciliation job, e.g. rate(1 day), disabled when empty"
+  default     = ""
+}
+
+variable "reconcile_repair" {
+  type        = bool
+  description = "Whether the scheduled reconciliation job repairs the drift or only reports it"
+  default     = false
+}
+
+variable "metrics_namespace" {
+  type        = string
+  description = "CloudWatch namespace of the stream processor metrics"
+  default     = "DynamoDBStreamOpenSearch"
+}
+
+variable "stream_lag_warn_threshold" {
+  type        = string
+  description = "Batch max lag above which the stream processor logs a warning, as a Go duration"
+  default     = "1m"
+}
+
+variable "stream_lag_alarm_threshold_ms" {
+  type        = number
+  description = "StreamLagMax and IteratorAge alarms threshold in milliseconds, 0 disables the alarms"
+  default     = 300000
+}
+
+variable "stream_source" {
+  type        = string
+  description = "Change stream read by the stream processor: dynamodb (DynamoDB Streams) or kinesis (Kinesis Data Streams for DynamoDB)"
+  default     = "dynamodb"
+
+  validation {
+    condition     = contains(["dynamodb", "kinesis"], var.stream_source)
+    error_message = "stream_source must be dynamodb or kinesis."
+  }
+}
+
+variable "kinesis_retention_hours" {
+  type        = number
+  description = "Retention of the Kinesis stream in hours, when stream_source is kinesis"
+  default     = 24
+}
+
+variable "stream_maximum_retry_attempts" {
+  type        = number
+  description = "Retries of a failing stream batch before its failing records are skipped and sent to the stream failures queue, -1 to retry until the records expire"
+  default     = 10
+}
@@ -0,0 +1,5 @@
+module aws-labs-lambdalog
+
+go 1.23
+
+require github.com/aws/aws-lambda-go v1.46.0
@@ -0,0 +1,101 @@
+// Package lambdalog is the structured JSON logger shared by the labs Lambdas.
+// Records are written to stdout, where CloudWatch Logs picks them up, with
+// the function name, the Lambda request ID and the domain IDs attached to
+// the context, so they can be queried with CloudWatch Logs Insights, e.g.
+//
+//	fields @timestamp, msg, sqs_message_id | filter chat_room = "room-1"
+package lambdalog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Domain ID attribute keys, shared across the labs so the same query works everywhere
+const (
+	KeyRequestID     = "request_id"
+	KeyFunctionName  = "function_name"
+	KeyS3Key         = "s3_key"
+	KeyStreamEventID = "stream_event_id"
+	KeySQSMessageID  = "sqs_message_id"
+	KeyChatRoom      = "chat_room"
+	KeyRunID         = "run_id"
+)
+
+type contextKey struct{}
+
+// Setup installs the JSON logger as the slog default, the standard log
+// package then writes through it too. The level is read from LOG_LEVEL
+// (debug, info, warn or error), info by default.
+func Setup() {
+	slog.SetDefault(New(os.Stdout))
+}
+
+// New creates the JSON logger writing to w
+func New(w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(os.Getenv("LOG_LEVEL")))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	logger := slog.New(&contextHandler{Handler: handler})
+	if lambdacontext.FunctionName != "" {
+		logger = logger.With(KeyFunctionName, lambdacontext.FunctionName)
+	}
+	return logger
+}
+
+// With returns a context carrying the attributes, e.g. a domain ID, added to
+// every record logged with it
+func With(ctx context.Context, args ...any) context.Context {
+	record := slog.Record{}
+	record.Add(args...)
+
+	attrs := append([]slog.Attr{}, attrsFromContext(ctx)...)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// Fatal logs the error and exits, for configuration errors found in init
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func attrsFromContext(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the request ID and the context attributes to the records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		record.AddAttrs(slog.String(KeyRequestID, lc.AwsRequestID))
+	}
+	record.AddAttrs(attrsFromContext(ctx)...)
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
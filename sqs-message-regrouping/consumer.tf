@@ -1,6 +1,11 @@
 resource "null_resource" "go_consumer_lambda_build" {
   triggers = {
-    source_code = filemd5("${path.module}/src/consumer/main.go")
+    source_code = sha1(join("", [
+      filemd5("${path.module}/src/consumer/main.go"),
+      filemd5("${path.module}/src/model/chat_message.go"),
+      filemd5("${path.module}/src/tracing/tracing.go"),
+      filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+    ]))
   }
 
   provisioner "local-exec" {
@@ -47,8 +52,9 @@ resource "aws_lambda_function" "consumer_lambda" {
 
   environment {
     variables = {
-      QUEUE_URL      = aws_sqs_queue.queue.id
-      DYNAMODB_TABLE = aws_dynamodb_table.table.name
+      QUEUE_URL                   = aws_sqs_queue.queue.id
+      DYNAMODB_TABLE              = aws_dynamodb_table.table.name
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
     }
   }
 
@@ -87,7 +93,7 @@ resource "aws_iam_role_policy" "write_to_table_policy_consumer" {
     Version = "2012-10-17"
     Statement = [
       {
-        Action   = "dynamodb:PutItem",
+        Action   = "dynamodb:UpdateItem",
         Effect   = "Allow"
         Resource = "${aws_dynamodb_table.table.arn}"
       }
@@ -1,13 +1,20 @@
 resource "null_resource" "go_sender_lambda_build" {
   triggers = {
-    source_code = filemd5("${path.module}/src/sender/main.go")
+    source_code = sha1(join("", concat(
+      [for f in sort(fileset("${path.module}/src/sender", "*.go")) : filemd5("${path.module}/src/sender/${f}")],
+      [
+        filemd5("${path.module}/src/model/chat_message.go"),
+        filemd5("${path.module}/src/tracing/tracing.go"),
+        filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+      ],
+    )))
   }
 
   provisioner "local-exec" {
     command = <<EOT
       cd ${path.module}/src/sender
       go mod tidy
-      GOOS=linux GOARCH=amd64 go build -o ../../dist/sender/bootstrap main.go
+      GOOS=linux GOARCH=amd64 go build -o ../../dist/sender/bootstrap .
       cd ../../dist/sender
       zip bootstrap.zip bootstrap
     EOT
@@ -54,7 +61,7 @@ resource "aws_iam_role_policy" "write_to_table_policy_sender" {
     Version = "2012-10-17"
     Statement = [
       {
-        Action   = "dynamodb:PutItem",
+        Action   = "dynamodb:BatchWriteItem",
         Effect   = "Allow"
         Resource = "${aws_dynamodb_table.table.arn}"
       }
@@ -68,7 +75,7 @@ resource "aws_lambda_function" "sender_lambda" {
   handler = "bootstrap"
   runtime = "provided.al2023"
 
-  timeout     = 30
+  timeout     = var.sender_timeout
   memory_size = 128
 
   filename         = "${path.module}/dist/sender/bootstrap.zip"
@@ -77,8 +84,10 @@ resource "aws_lambda_function" "sender_lambda" {
 
   environment {
     variables = {
-      QUEUE_URL      = aws_sqs_queue.queue.url
-      DYNAMODB_TABLE = aws_dynamodb_table.table.name
+      QUEUE_URL                   = aws_sqs_queue.queue.url
+      SENDER_WORKERS              = var.sender_workers
+      DYNAMODB_TABLE              = aws_dynamodb_table.table.name
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
     }
   }
 
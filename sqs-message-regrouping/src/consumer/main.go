@@ -2,27 +2,40 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 	"time"
 
+	lambdalog "aws-labs-lambdalog"
 	model "sqs-message-regrouping-model"
+	tracing "sqs-message-regrouping-tracing"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var dynamoTable *dynamodb.Client
 var dynamoTableName = os.Getenv("DYNAMODB_TABLE")
 var dynamoTableOptions *model.TableOptions
+var flushTraces func(context.Context)
+var tracer = otel.Tracer("sqs-message-regrouping-consumer")
 
 func init() {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		panic(err)
 	}
+	flushTraces, err = tracing.Init(context.TODO(), "sqs-message-regrouping-consumer")
+	if err != nil {
+		panic(err)
+	}
 	dynamoTable = dynamodb.NewFromConfig(cfg)
 	dynamoTableOptions = &model.TableOptions{
 		DynamoDbClient: dynamoTable,
@@ -30,24 +43,79 @@ func init() {
 	}
 }
 
-func HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) {
+// HandleRequest reports the records not processed as batch item failures so
+// they are not deleted from the queue. When a record fails the later records
+// of its message group in the batch are failed without being processed, the
+// group order is kept when they are received again.
+func HandleRequest(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	defer flushTraces(ctx)
+
+	batchItemFailures := []events.SQSBatchItemFailure{}
+	failedGroups := make(map[string]bool)
 	for _, record := range sqsEvent.Records {
+		chatRoomId := record.Attributes["MessageGroupId"]
+		recordCtx := lambdalog.With(ctx,
+			lambdalog.KeySQSMessageID, record.MessageId,
+			lambdalog.KeyChatRoom, chatRoomId,
+		)
+		if runId := record.MessageAttributes["RunId"].StringValue; runId != nil {
+			recordCtx = lambdalog.With(recordCtx, lambdalog.KeyRunID, *runId)
+		}
+
+		if failedGroups[chatRoomId] {
+			slog.WarnContext(recordCtx, "Record skipped after a failure in its group")
+			batchItemFailures = append(batchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+			continue
+		}
+
 		time.Sleep(1 * time.Second)
-		err := processRecord(ctx, record, dynamoTableOptions)
+		err := processRecord(recordCtx, record, dynamoTableOptions)
 
 		if err != nil {
-			fmt.Printf("Error processing record %s: %v\n", record.MessageId, err)
+			slog.ErrorContext(recordCtx, "Error processing record", "error", err)
+			failedGroups[chatRoomId] = true
+			batchItemFailures = append(batchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+			continue
 		}
+		slog.InfoContext(recordCtx, "Record processed")
 	}
+
+	return events.SQSEventResponse{
+		BatchItemFailures: batchItemFailures,
+	}, nil
 }
 
 func main() {
+	lambdalog.Setup()
 	lambda.Start(HandleRequest)
 }
 
-func processRecord(ctx context.Context, record events.SQSMessage, options *model.TableOptions) error {
+func processRecord(ctx context.Context, record events.SQSMessage, options *model.TableOptions) (err error) {
 	chatRoomId := record.Attributes["MessageGroupId"]
+
+	// The span continues the trace of the sender SendMessage span
+	ctx, span := tracer.Start(tracing.ExtractSQS(ctx, record.MessageAttributes), "processRecord",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.message.id", record.MessageId),
+			attribute.String("chat.room", chatRoomId),
+		),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	sender := record.MessageAttributes["Sender"]
+	runId := record.MessageAttributes["RunId"]
 	messageId := record.MessageId
 	messageBody := record.Body
 
@@ -59,6 +127,15 @@ func processRecord(ctx context.Context, record events.SQSMessage, options *model
 		Status:         "RECEIVED",
 		CreatedAt:      time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
 	}
+	// Messages sent before run IDs were introduced have no RunId attribute
+	if runId.StringValue != nil {
+		chatMessage.RunId = *runId.StringValue
+	}
+	// nor Sequence attribute, the FIFO sequence number is always set
+	if sequence := record.MessageAttributes["Sequence"].StringValue; sequence != nil {
+		chatMessage.Sequence, _ = strconv.Atoi(*sequence)
+	}
+	chatMessage.SequenceNumber = record.Attributes["SequenceNumber"]
 
-	return options.AddChatMessage(ctx, chatMessage)
+	return options.AddReceivedMessage(ctx, chatMessage)
 }
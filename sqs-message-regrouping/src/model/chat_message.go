@@ -2,22 +2,49 @@ package model
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go/aws"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// batchWriteSize is the maximum number of items of a BatchWriteItem call
+const batchWriteSize = 25
+
+// batchWriteAttempts bounds the retries of the unprocessed items
+const batchWriteAttempts = 5
+
 var RoomIndex = "RoomIndex"
+var RunIndex = "RunIndex"
+
+var tracer = otel.Tracer("sqs-message-regrouping-model")
 
 type ChatMessage struct {
 	Room           string `json:"ChatRoom"`
 	MessageId      string `json:"MessageId"`
 	MessageContent string `json:"MessageContent"`
 	Sender         string `json:"Sender"`
-	Status         string `json:"Status"` // Status can be "SENT" or "RECEIVED"
+	Status         string `json:"Status"` // Status can be "SENT", "RECEIVED" or "DUPLICATE" (a deliberate duplicate send)
 	CreatedAt      string `json:"CreatedAt"`
+	RunId          string `json:"RunId,omitempty" dynamodbav:",omitempty"` // RunId identifies the sender run
+	// Sequence is the position of the message in its room for the run, from 1, assigned by the sender
+	Sequence int `json:"Sequence,omitempty" dynamodbav:",omitempty"`
+	// SequenceNumber is the sequence number given by the FIFO queue, a large number as a string
+	SequenceNumber string `json:"SequenceNumber,omitempty" dynamodbav:",omitempty"`
+	// ReceiveCount counts the deliveries of a RECEIVED message, a redelivered
+	// message keeping its MessageId and so its item
+	ReceiveCount int `json:"ReceiveCount,omitempty" dynamodbav:",omitempty"`
+	// ProcessedPosition is the position of the first processing of a RECEIVED
+	// message in its room for the run, from 1, assigned by the consumer
+	ProcessedPosition int `json:"ProcessedPosition,omitempty" dynamodbav:",omitempty"`
 }
 
 type TableOptions struct {
@@ -25,7 +52,10 @@ type TableOptions struct {
 	TableName      string
 }
 
-func (tableOpts TableOptions) AddChatMessage(ctx context.Context, message ChatMessage) error {
+func (tableOpts TableOptions) AddChatMessage(ctx context.Context, message ChatMessage) (err error) {
+	ctx, span := tableOpts.startSpan(ctx, "PutItem", attribute.String("chat.room", message.Room), attribute.String("chat.status", message.Status))
+	defer func() { endSpan(span, err) }()
+
 	item, err := attributevalue.MarshalMap(message)
 	if err != nil {
 		panic(err)
@@ -36,10 +66,135 @@ func (tableOpts TableOptions) AddChatMessage(ctx context.Context, message ChatMe
 	return err
 }
 
-func (tableOpts TableOptions) QueryByRoom(ctx context.Context, room string) ([]ChatMessage, error) {
-	var err error
+// AddChatMessages writes the messages with BatchWriteItem, the items left
+// unprocessed by a call are retried with an exponential backoff
+func (tableOpts TableOptions) AddChatMessages(ctx context.Context, messages []ChatMessage) (err error) {
+	ctx, span := tableOpts.startSpan(ctx, "BatchWriteItem", attribute.Int("chat.messages", len(messages)))
+	defer func() { endSpan(span, err) }()
+
+	for start := 0; start < len(messages); start += batchWriteSize {
+		end := min(start+batchWriteSize, len(messages))
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, message := range messages[start:end] {
+			item, err := attributevalue.MarshalMap(message)
+			if err != nil {
+				return err
+			}
+			requests = append(requests, types.WriteRequest{PutRequest: &types.PutRequest{Item: item}})
+		}
+		if err = tableOpts.batchWrite(ctx, requests); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tableOpts TableOptions) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	delay := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		response, err := tableOpts.DynamoDbClient.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{tableOpts.TableName: requests},
+		})
+		if err != nil {
+			return err
+		}
+		requests = response.UnprocessedItems[tableOpts.TableName]
+		if len(requests) == 0 {
+			return nil
+		}
+		if attempt == batchWriteAttempts {
+			return fmt.Errorf("%d items still unprocessed after %d attempts", len(requests), attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// AddReceivedMessage records a delivery of the message: the attributes of the
+// first delivery are kept, including its ProcessedPosition taken from the room
+// counter, and ReceiveCount is incremented
+func (tableOpts TableOptions) AddReceivedMessage(ctx context.Context, message ChatMessage) (err error) {
+	ctx, span := tableOpts.startSpan(ctx, "UpdateItem", attribute.String("chat.room", message.Room), attribute.String("chat.status", message.Status))
+	defer func() { endSpan(span, err) }()
+
+	message.ProcessedPosition, err = tableOpts.nextProcessedPosition(ctx, message.RunId, message.Room)
+	if err != nil {
+		return err
+	}
+
+	item, err := attributevalue.MarshalMap(message)
+	if err != nil {
+		return err
+	}
+	key := map[string]types.AttributeValue{
+		"MessageId": item["MessageId"],
+		"Status":    item["Status"],
+	}
+
+	update := expression.Add(expression.Name("ReceiveCount"), expression.Value(1))
+	for name, value := range item {
+		if _, isKey := key[name]; isKey || name == "ReceiveCount" {
+			continue
+		}
+		update = update.Set(expression.Name(name), expression.IfNotExists(expression.Name(name), expression.Value(value)))
+	}
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = tableOpts.DynamoDbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableOpts.TableName),
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	return err
+}
+
+// nextProcessedPosition increments the count of the messages processed in the
+// room for the run and returns it. The counter item has no Room attribute, so
+// it is left out of the room and run indexes.
+func (tableOpts TableOptions) nextProcessedPosition(ctx context.Context, runId string, room string) (int, error) {
+	update := expression.Add(expression.Name("Count"), expression.Value(1))
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := tableOpts.DynamoDbClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableOpts.TableName),
+		Key: map[string]types.AttributeValue{
+			"MessageId": &types.AttributeValueMemberS{Value: fmt.Sprintf("PROCESSED#%s#%s", runId, room)},
+			"Status":    &types.AttributeValueMemberS{Value: "COUNTER"},
+		},
+		UpdateExpression:          expr.Update(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var counter struct{ Count int }
+	if err := attributevalue.UnmarshalMap(response.Attributes, &counter); err != nil {
+		return 0, err
+	}
+	return counter.Count, nil
+}
+
+func (tableOpts TableOptions) QueryByRoom(ctx context.Context, room string) (messages []ChatMessage, err error) {
+	ctx, span := tableOpts.startSpan(ctx, "Query", attribute.String("chat.room", room))
+	defer func() { endSpan(span, err) }()
+
 	var response *dynamodb.QueryOutput
-	var messages []ChatMessage
 
 	keyEx := expression.Key("Room").Equal(expression.Value(room))
 	expr, err := expression.NewBuilder().WithKeyCondition(keyEx).Build()
@@ -70,3 +225,86 @@ func (tableOpts TableOptions) QueryByRoom(ctx context.Context, room string) ([]C
 
 	return messages, err
 }
+
+// QueryByRun returns the messages of a sender run, only the ones of the room when set
+func (tableOpts TableOptions) QueryByRun(ctx context.Context, runId string, room string) (messages []ChatMessage, err error) {
+	ctx, span := tableOpts.startSpan(ctx, "Query", attribute.String("chat.run_id", runId), attribute.String("chat.room", room))
+	defer func() { endSpan(span, err) }()
+
+	keyEx := expression.Key("RunId").Equal(expression.Value(runId))
+	if room != "" {
+		keyEx = keyEx.And(expression.Key("Room").Equal(expression.Value(room)))
+	}
+	expr, err := expression.NewBuilder().WithKeyCondition(keyEx).Build()
+	if err != nil {
+		return messages, err
+	}
+
+	queryPaginator := dynamodb.NewQueryPaginator(tableOpts.DynamoDbClient, &dynamodb.QueryInput{
+		TableName:                 aws.String(tableOpts.TableName),
+		IndexName:                 aws.String(RunIndex),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+	})
+
+	for queryPaginator.HasMorePages() {
+		response, err := queryPaginator.NextPage(ctx)
+		if err != nil {
+			return messages, err
+		}
+		var messagesPage []ChatMessage
+		err = attributevalue.UnmarshalListOfMaps(response.Items, &messagesPage)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, messagesPage...)
+	}
+
+	return messages, nil
+}
+
+func (tableOpts TableOptions) ScanMessages(ctx context.Context) (messages []ChatMessage, err error) {
+	ctx, span := tableOpts.startSpan(ctx, "Scan")
+	defer func() { endSpan(span, err) }()
+
+	scanPaginator := dynamodb.NewScanPaginator(tableOpts.DynamoDbClient, &dynamodb.ScanInput{
+		TableName: aws.String(tableOpts.TableName),
+	})
+
+	for scanPaginator.HasMorePages() {
+		response, err := scanPaginator.NextPage(ctx)
+		if err != nil {
+			return messages, err
+		}
+		var messagesPage []ChatMessage
+		err = attributevalue.UnmarshalListOfMaps(response.Items, &messagesPage)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, messagesPage...)
+	}
+
+	return messages, nil
+}
+
+// startSpan starts a client span around a DynamoDB call
+func (tableOpts TableOptions) startSpan(ctx context.Context, operation string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "DynamoDB."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "dynamodb"),
+			attribute.String("db.operation", operation),
+			attribute.String("aws.dynamodb.table_names", tableOpts.TableName),
+		),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	model "sqs-message-regrouping-model"
+)
+
+// Deduplication sets how the queue deduplicates the messages of a run. The
+// queue drops a message with the deduplication ID of a message accepted in
+// the last 5 minutes, the ID is the SHA-256 of the body with the content
+// based deduplication of the queue.
+type Deduplication struct {
+	// ExplicitIds sets the deduplication ID of the messages from the run, the
+	// room and the sequence instead of the body
+	ExplicitIds bool `json:"ExplicitIds"`
+	// DuplicateRate is the share of the messages sent a second time right
+	// after their batch, the queue must drop these duplicates
+	DuplicateRate float64 `json:"DuplicateRate"`
+}
+
+func (dedup Deduplication) validate() error {
+	if dedup.DuplicateRate < 0 || dedup.DuplicateRate > 1 {
+		return fmt.Errorf("invalid input: Deduplication.DuplicateRate must be between 0 and 1")
+	}
+	return nil
+}
+
+// deduplicationId is a hash to stay within the 128 characters allowed
+// whatever the room name
+func deduplicationId(runId string, chatRoom string, sequence int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", runId, chatRoom, sequence)))
+	return hex.EncodeToString(hash[:])
+}
+
+// sendDuplicates sends again a DuplicateRate share of the batch, with the
+// same body and deduplication ID, and records the sends as DUPLICATE with the
+// message ID of the original message for the tester to check they were dropped
+func sendDuplicates(ctx context.Context, sendCtx context.Context, run *senderRun, chatRoom string, batch []outgoingMessage, sent []model.ChatMessage) error {
+	if run.dedup.DuplicateRate == 0 {
+		return nil
+	}
+
+	var duplicates []outgoingMessage
+	var records []model.ChatMessage
+	for i, message := range batch {
+		if rand.Float64() >= run.dedup.DuplicateRate {
+			continue
+		}
+		duplicates = append(duplicates, message)
+		record := sent[i]
+		record.Status = "DUPLICATE"
+		records = append(records, record)
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	if _, err := sendMessageBatch(sendCtx, run.id, chatRoom, duplicates); err != nil {
+		return fmt.Errorf("failed to send duplicates: %w", err)
+	}
+	createdAt := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+	for i := range records {
+		records[i].CreatedAt = createdAt
+	}
+	if err := dynamoTableOptions.AddChatMessages(ctx, records); err != nil {
+		return fmt.Errorf("failed to add duplicates to DynamoDB: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Duplicates sent", "count", len(duplicates))
+	return nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxBatchBodyBytes keeps a SendMessageBatch call under the 256 KiB payload
+// limit, leaving room for the message attributes
+const maxBatchBodyBytes = 250 * 1024
+
+// LoadProfile shapes the traffic of a run, its zero value sends the messages
+// of every room as fast as possible
+type LoadProfile struct {
+	// RatePerRoom limits the messages per second of each room, 0 for no limit
+	RatePerRoom float64 `json:"RatePerRoom"`
+	// Rate limits the messages per second of the whole run, 0 for no limit
+	Rate float64 `json:"Rate"`
+	// RampUpSeconds raises the rates linearly from 0 to their value
+	RampUpSeconds int `json:"RampUpSeconds"`
+	// BurstSize messages are sent back to back by a room every BurstIntervalMs
+	BurstSize       int `json:"BurstSize"`
+	BurstIntervalMs int `json:"BurstIntervalMs"`
+	// RoomSkew is the exponent of a Zipf distribution of the messages and of
+	// the room rates over the rooms, the first rooms being the hot ones, 0
+	// for an even distribution
+	RoomSkew    float64     `json:"RoomSkew"`
+	MessageSize MessageSize `json:"MessageSize"`
+	// DurationSeconds sends until the duration elapses instead of a message
+	// count, MessageCountByRoom then caps the rooms when set
+	DurationSeconds int `json:"DurationSeconds"`
+}
+
+// MessageSize is the distribution of the message body sizes, bodies are
+// padded up to the drawn size. The zero value leaves the bodies unpadded.
+type MessageSize struct {
+	MinBytes int `json:"MinBytes"`
+	MaxBytes int `json:"MaxBytes"`
+	// Distribution is "uniform" (default) or "exponential", the latter
+	// favouring small messages with a mean a quarter of the range above MinBytes
+	Distribution string `json:"Distribution"`
+}
+
+func (profile LoadProfile) validate() error {
+	switch {
+	case profile.RatePerRoom < 0 || profile.Rate < 0 || profile.RoomSkew < 0:
+		return fmt.Errorf("invalid load: RatePerRoom, Rate and RoomSkew must not be negative")
+	case profile.RampUpSeconds < 0 || profile.DurationSeconds < 0:
+		return fmt.Errorf("invalid load: RampUpSeconds and DurationSeconds must not be negative")
+	case profile.RampUpSeconds > 0 && profile.RatePerRoom == 0 && profile.Rate == 0:
+		return fmt.Errorf("invalid load: RampUpSeconds requires RatePerRoom or Rate")
+	case (profile.BurstSize > 0) != (profile.BurstIntervalMs > 0) || profile.BurstSize < 0 || profile.BurstIntervalMs < 0:
+		return fmt.Errorf("invalid load: BurstSize and BurstIntervalMs must be set together")
+	}
+
+	size := profile.MessageSize
+	if size.MinBytes < 0 || size.MaxBytes < size.MinBytes || size.MaxBytes > maxBatchBodyBytes {
+		return fmt.Errorf("invalid load: MessageSize must have 0 <= MinBytes <= MaxBytes <= %d", maxBatchBodyBytes)
+	}
+	if size.Distribution != "" && size.Distribution != "uniform" && size.Distribution != "exponential" {
+		return fmt.Errorf("invalid load: unknown MessageSize distribution %q", size.Distribution)
+	}
+	return nil
+}
+
+// loadRun paces the rooms of a run. The send times of a room are computed
+// from the room start, so the pauses of a room do not shift its later
+// messages and a room waiting for a worker is still ramped up and paced.
+type loadRun struct {
+	profile LoadProfile
+	start   time.Time
+	// end stops a duration based run, zero for a count based one
+	end    time.Time
+	global *globalPacer
+}
+
+func newLoadRun(profile LoadProfile) *loadRun {
+	start := time.Now()
+	run := &loadRun{
+		profile: profile,
+		start:   start,
+		global: &globalPacer{
+			pacer: pacer{rate: profile.Rate, rampUp: time.Duration(profile.RampUpSeconds) * time.Second},
+			start: start,
+		},
+	}
+	if profile.DurationSeconds > 0 {
+		run.end = start.Add(time.Duration(profile.DurationSeconds) * time.Second)
+	}
+	return run
+}
+
+// roomLoad is the share of a room in the run
+type roomLoad struct {
+	// messageCount is 0 for no limit in a duration based run
+	messageCount int
+	pacer        pacer
+	// start is set when a worker starts sending to the room
+	start time.Time
+}
+
+// validateRooms rejects the duration based runs with more rooms than
+// workers, the rooms left waiting for a worker would start at the run end
+func (profile LoadProfile) validateRooms(roomCount int, workers int) error {
+	if profile.DurationSeconds > 0 && roomCount > workers {
+		return fmt.Errorf("invalid input: a duration based run sends to at most %d rooms (SENDER_WORKERS), got %d", workers, roomCount)
+	}
+	return nil
+}
+
+// rooms spreads messageCount messages per room on average and the room rate
+// over the rooms according to the skew
+func (run *loadRun) rooms(roomCount int, messageCount int) []roomLoad {
+	weights := roomWeights(roomCount, run.profile.RoomSkew)
+	rooms := make([]roomLoad, 0, roomCount)
+	for _, weight := range weights {
+		room := roomLoad{pacer: pacer{
+			rate:   run.profile.RatePerRoom * weight,
+			rampUp: time.Duration(run.profile.RampUpSeconds) * time.Second,
+		}}
+		if messageCount > 0 {
+			room.messageCount = max(1, int(math.Round(float64(messageCount)*weight)))
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// roomWeights are Zipf weights of exponent skew averaging to 1
+func roomWeights(roomCount int, skew float64) []float64 {
+	weights := make([]float64, roomCount)
+	var total float64
+	for i := range weights {
+		weights[i] = 1 / math.Pow(float64(i+1), skew)
+		total += weights[i]
+	}
+	for i := range weights {
+		weights[i] *= float64(roomCount) / total
+	}
+	return weights
+}
+
+func (room roomLoad) hasNext(i int) bool {
+	return room.messageCount == 0 || i < room.messageCount
+}
+
+// at is the earliest send time of the message i of the room
+func (run *loadRun) at(room roomLoad, i int) time.Time {
+	at := room.pacer.at(room.start, i)
+	if run.profile.BurstSize > 0 {
+		burst := i / run.profile.BurstSize
+		burstStart := room.start.Add(time.Duration(burst*run.profile.BurstIntervalMs) * time.Millisecond)
+		if burstStart.After(at) {
+			at = burstStart
+		}
+	}
+	return at
+}
+
+func (run *loadRun) ended(now time.Time) bool {
+	return !run.end.IsZero() && !now.Before(run.end)
+}
+
+// nextBatch waits for the message first of the room to be due and returns it
+// with the following ones already due, up to a SendMessageBatch call. The
+// batch is empty when the run ended.
+func (run *loadRun) nextBatch(ctx context.Context, room roomLoad, first int) ([]outgoingMessage, error) {
+	if err := sleepUntil(ctx, run.at(room, first)); err != nil {
+		return nil, err
+	}
+	if err := sleepUntil(ctx, run.global.reserve()); err != nil {
+		return nil, err
+	}
+	if run.ended(time.Now()) {
+		return nil, nil
+	}
+
+	batch := []outgoingMessage{run.message(first)}
+	size := len(batch[0].Body)
+	for i := first + 1; len(batch) < sqsBatchSize && room.hasNext(i); i++ {
+		now := time.Now()
+		if run.at(room, i).After(now) {
+			break
+		}
+		message := run.message(i)
+		if size+len(message.Body) > maxBatchBodyBytes || !run.global.tryReserve(now) {
+			break
+		}
+		batch = append(batch, message)
+		size += len(message.Body)
+	}
+	return batch, nil
+}
+
+// message builds the message i of a room, its sequence starts from 1
+func (run *loadRun) message(i int) outgoingMessage {
+	// The sequence orders the room messages without relying on the clocks
+	sequence := i + 1
+	sender := "BOT"
+	if i%2 == 0 {
+		sender = "USER"
+	}
+
+	body := fmt.Sprintf("%d: %s", sequence, uuid.New().String())
+	if padding := run.messageSize() - len(body); padding > 0 {
+		body += " " + strings.Repeat("x", padding-1)
+	}
+
+	return outgoingMessage{
+		Id:       strconv.Itoa(sequence),
+		Sequence: sequence,
+		Body:     body,
+		Sender:   sender,
+	}
+}
+
+func (run *loadRun) messageSize() int {
+	size := run.profile.MessageSize
+	spread := size.MaxBytes - size.MinBytes
+	if spread == 0 {
+		return size.MinBytes
+	}
+	if size.Distribution == "exponential" {
+		return size.MinBytes + min(spread, int(rand.ExpFloat64()*float64(spread)/4))
+	}
+	return size.MinBytes + rand.IntN(spread+1)
+}
+
+// pacer spaces messages at a rate reached linearly over rampUp, the count of
+// messages due after t seconds is rate*t²/(2*rampUp) during the ramp up and
+// rate*(t-rampUp/2) after it
+type pacer struct {
+	// rate is in messages per second, 0 for no limit
+	rate   float64
+	rampUp time.Duration
+}
+
+// at is the send time of the message k, numbered from 0
+func (p pacer) at(start time.Time, k int) time.Time {
+	if p.rate <= 0 {
+		return start
+	}
+	rampUp := p.rampUp.Seconds()
+	n := float64(k)
+	var seconds float64
+	if n <= p.rate*rampUp/2 {
+		seconds = math.Sqrt(2 * rampUp * n / p.rate)
+	} else {
+		seconds = n/p.rate + rampUp/2
+	}
+	return start.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// globalPacer hands out the send times of the run rate to the rooms
+type globalPacer struct {
+	pacer pacer
+	start time.Time
+
+	mu   sync.Mutex
+	next int
+}
+
+// reserve takes the next send time
+func (g *globalPacer) reserve() time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	at := g.pacer.at(g.start, g.next)
+	g.next++
+	return at
+}
+
+// tryReserve takes the next send time only when it is already due
+func (g *globalPacer) tryReserve(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pacer.at(g.start, g.next).After(now) {
+		return false
+	}
+	g.next++
+	return true
+}
+
+func sleepUntil(ctx context.Context, at time.Time) error {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
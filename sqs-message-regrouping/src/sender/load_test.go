@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacerAt(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		pacer pacer
+		k     int
+		want  time.Duration
+	}{
+		{name: "no limit", pacer: pacer{}, k: 50, want: 0},
+		{name: "constant rate", pacer: pacer{rate: 10}, k: 25, want: 2500 * time.Millisecond},
+		{name: "first message of the ramp up", pacer: pacer{rate: 10, rampUp: 2 * time.Second}, k: 0, want: 0},
+		{name: "end of the ramp up", pacer: pacer{rate: 10, rampUp: 2 * time.Second}, k: 10, want: 2 * time.Second},
+		{name: "after the ramp up", pacer: pacer{rate: 10, rampUp: 2 * time.Second}, k: 20, want: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pacer.at(start, tt.k).Sub(start); got != tt.want {
+				t.Errorf("at(%d) = %v, want %v", tt.k, got, tt.want)
+			}
+		})
+	}
+}
+
+// A room started late by a busy worker is paced from its own start, its
+// backlog is not sent at once
+func TestLoadRunAtLateRoom(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile LoadProfile
+		i       int
+		want    time.Duration
+	}{
+		{name: "first message", profile: LoadProfile{RatePerRoom: 10}, i: 0, want: 0},
+		{name: "rate", profile: LoadProfile{RatePerRoom: 10}, i: 10, want: time.Second},
+		{name: "ramp up", profile: LoadProfile{RatePerRoom: 10, RampUpSeconds: 2}, i: 10, want: 2 * time.Second},
+		{name: "burst", profile: LoadProfile{BurstSize: 5, BurstIntervalMs: 1000}, i: 12, want: 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := newLoadRun(tt.profile)
+			room := run.rooms(1, 100)[0]
+			room.start = run.start.Add(time.Hour)
+
+			if got := run.at(room, tt.i).Sub(room.start); got != tt.want {
+				t.Errorf("at(%d) = %v after the room start, want %v", tt.i, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRooms(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile LoadProfile
+		rooms   int
+		wantErr bool
+	}{
+		{name: "count based run with more rooms than workers", profile: LoadProfile{}, rooms: 50},
+		{name: "duration based run within the workers", profile: LoadProfile{DurationSeconds: 60}, rooms: 10},
+		{name: "duration based run with more rooms than workers", profile: LoadProfile{DurationSeconds: 60}, rooms: 11, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.validateRooms(tt.rooms, 10)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRooms() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoomWeights(t *testing.T) {
+	weights := roomWeights(4, 1.2)
+
+	var total float64
+	for i, weight := range weights {
+		total += weight
+		if i > 0 && weight >= weights[i-1] {
+			t.Errorf("weight %d = %v, want less than %v", i, weight, weights[i-1])
+		}
+	}
+	if total < 3.999 || total > 4.001 {
+		t.Errorf("weights sum = %v, want 4", total)
+	}
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	lambdalog "aws-labs-lambdalog"
 	model "sqs-message-regrouping-model"
+	tracing "sqs-message-regrouping-tracing"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -17,6 +20,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var sqsClient *sqs.Client
@@ -24,12 +31,31 @@ var queueURL = os.Getenv("QUEUE_URL")
 var dynamoTable *dynamodb.Client
 var dynamoTableName = os.Getenv("DYNAMODB_TABLE")
 var dynamoTableOptions *model.TableOptions
+var flushTraces func(context.Context)
+var tracer = otel.Tracer("sqs-message-regrouping-sender")
+
+// senderWorkers bounds the rooms sent to at the same time
+var senderWorkers = 10
+
+// deadlineMargin is left before the Lambda deadline to record the messages
+// already sent and return the result
+const deadlineMargin = 3 * time.Second
 
 func init() {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		panic(err)
 	}
+	flushTraces, err = tracing.Init(context.TODO(), "sqs-message-regrouping-sender")
+	if err != nil {
+		panic(err)
+	}
+	if workers := os.Getenv("SENDER_WORKERS"); workers != "" {
+		senderWorkers, err = strconv.Atoi(workers)
+		if err != nil || senderWorkers <= 0 {
+			panic(fmt.Errorf("invalid SENDER_WORKERS %q", workers))
+		}
+	}
 	sqsClient = sqs.NewFromConfig(cfg)
 	dynamoTable = dynamodb.NewFromConfig(cfg)
 	dynamoTableOptions = &model.TableOptions{
@@ -38,103 +64,335 @@ func init() {
 	}
 }
 
+// SenderEvent lists the rooms to send to, ChatRoomIds and RoomCount rooms
+// named after RoomTemplate can be combined
 type SenderEvent struct {
-	MessageCountByRoom int      `json:"MessageCountByRoom"`
-	ChatRoomIds        []string `json:"ChatRoomIds"`
+	MessageCountByRoom int           `json:"MessageCountByRoom"`
+	ChatRoomIds        []string      `json:"ChatRoomIds"`
+	RoomCount          int           `json:"RoomCount"`
+	RoomTemplate       string        `json:"RoomTemplate"`
+	Load               LoadProfile   `json:"Load"`
+	Deduplication      Deduplication `json:"Deduplication"`
 }
 
-func HandleRequest(ctx context.Context, event SenderEvent) (map[string][]string, error) {
-	if event.MessageCountByRoom <= 0 || len(event.ChatRoomIds) == 0 {
-		return nil, fmt.Errorf("invalid input: MessageCountByRoom must be greater than 0 and ChatRoomIds must not be empty")
+// SenderResult gives the run ID and the rooms to pass to the tester with the
+// sent message IDs by room
+type SenderResult struct {
+	RunId       string              `json:"RunId"`
+	ChatRoomIds []string            `json:"ChatRoomIds"`
+	MessageIds  map[string][]string `json:"MessageIds"`
+	// Errors gives the error of each failed room, MessageIds keeping the
+	// messages sent before the failure
+	Errors map[string]string `json:"Errors,omitempty"`
+}
+
+// senderRun is the configuration shared by the rooms of an invocation
+type senderRun struct {
+	id    string
+	load  *loadRun
+	dedup Deduplication
+	// sendDeadline stops the sends before the Lambda deadline, zero for none
+	sendDeadline time.Time
+}
+
+// roomResult is the outcome of a room, the IDs of the messages sent before
+// a failure are kept
+type roomResult struct {
+	chatRoom   string
+	messageIds []string
+	err        error
+}
+
+func HandleRequest(ctx context.Context, event SenderEvent) (*SenderResult, error) {
+	defer flushTraces(ctx)
+	ctx, span := tracer.Start(ctx, "HandleRequest")
+	defer span.End()
+
+	if event.MessageCountByRoom < 0 || (event.MessageCountByRoom == 0 && event.Load.DurationSeconds == 0) {
+		return nil, fmt.Errorf("invalid input: MessageCountByRoom must be greater than 0, or Load.DurationSeconds set")
+	}
+	if err := event.Load.validate(); err != nil {
+		return nil, err
+	}
+	if err := event.Deduplication.validate(); err != nil {
+		return nil, err
 	}
 
-	var wg sync.WaitGroup
-	resultsChan := make(chan map[string][]string, len(event.ChatRoomIds))
-	errorChannel := make(chan error, len(event.ChatRoomIds))
+	// The run ID scopes the messages of this invocation, rooms can be reused across runs
+	runId := uuid.New().String()
+	ctx = lambdalog.With(ctx, lambdalog.KeyRunID, runId)
+	span.SetAttributes(attribute.String("chat.run_id", runId))
 
-	for _, chatRoom := range event.ChatRoomIds {
+	chatRoomIds, err := chatRooms(event, runId)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("chat.rooms", len(chatRoomIds)))
+
+	run := &senderRun{id: runId, load: newLoadRun(event.Load), dedup: event.Deduplication}
+	if deadline, ok := ctx.Deadline(); ok {
+		run.sendDeadline = deadline.Add(-deadlineMargin)
+	}
+	rooms := run.load.rooms(len(chatRoomIds), event.MessageCountByRoom)
+
+	if err := event.Load.validateRooms(len(chatRoomIds), senderWorkers); err != nil {
+		return nil, err
+	}
+	workers := min(senderWorkers, len(chatRoomIds))
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	results := make(chan roomResult, len(chatRoomIds))
+	for w := 0; w < workers; w++ {
 		wg.Add(1)
-		go func(chatRoom string) {
+		go func() {
 			defer wg.Done()
-			sentMessageIds, err := sendMessagesToChatRoom(ctx, chatRoom, event.MessageCountByRoom)
-			errorChannel <- err
-			resultsChan <- sentMessageIds
-		}(chatRoom)
+			for i := range jobs {
+				messageIds, err := sendMessagesToChatRoom(ctx, run, chatRoomIds[i], rooms[i])
+				results <- roomResult{chatRoom: chatRoomIds[i], messageIds: messageIds, err: err}
+			}
+		}()
+	}
+	for i := range chatRoomIds {
+		jobs <- i
 	}
+	close(jobs)
 
 	wg.Wait()
-	close(resultsChan)
-	close(errorChannel)
+	close(results)
 
-	fResults := collectResults(resultsChan)
-	fErrors := collectErrors(errorChannel)
-
-	return fResults, errors.Join(fErrors...)
+	return collectResults(ctx, runId, chatRoomIds, results), nil
 }
 
 func main() {
+	lambdalog.Setup()
 	lambda.Start(HandleRequest)
 }
 
-func collectErrors(errorChannel <-chan error) []error {
-	var errors []error
-	for err := range errorChannel {
-		if err != nil {
-			errors = append(errors, err)
+// collectResults returns the partial results when rooms failed, with the
+// error of each failed room, as an invocation error would drop them
+func collectResults(ctx context.Context, runId string, chatRoomIds []string, results <-chan roomResult) *SenderResult {
+	senderResult := &SenderResult{
+		RunId:       runId,
+		ChatRoomIds: chatRoomIds,
+		MessageIds:  make(map[string][]string),
+	}
+	for result := range results {
+		senderResult.MessageIds[result.chatRoom] = result.messageIds
+		if result.err != nil {
+			if senderResult.Errors == nil {
+				senderResult.Errors = make(map[string]string)
+			}
+			senderResult.Errors[result.chatRoom] = result.err.Error()
+			slog.ErrorContext(lambdalog.With(ctx, lambdalog.KeyChatRoom, result.chatRoom), "Room failed",
+				"sent", len(result.messageIds),
+				"error", result.err,
+			)
 		}
 	}
-	return errors
+	return senderResult
+}
+
+// sqsBatchSize is the maximum number of entries of a SendMessageBatch call
+const sqsBatchSize = 10
+
+// sendBatchAttempts bounds the resends of a batch with failed entries
+const sendBatchAttempts = 5
+
+// outgoingMessage is a chat message to send, Id identifies it in its batch
+type outgoingMessage struct {
+	Id       string
+	Sequence int
+	Body     string
+	Sender   string
+	// DeduplicationId is empty to rely on the queue content based deduplication
+	DeduplicationId string
 }
 
-func collectResults(resultsChan <-chan map[string][]string) map[string][]string {
-	finalResults := make(map[string][]string)
-	for result := range resultsChan {
-		for chatRoom, messageIds := range result {
-			finalResults[chatRoom] = append(finalResults[chatRoom], messageIds...)
+// sendMessagesToChatRoom sends the room messages until its count or the run
+// end. The sends stop at the run send deadline but the DynamoDB records of
+// the messages already sent are still written with the invocation context.
+func sendMessagesToChatRoom(ctx context.Context, run *senderRun, chatRoom string, room roomLoad) ([]string, error) {
+	ctx = lambdalog.With(ctx, lambdalog.KeyChatRoom, chatRoom)
+	room.start = time.Now()
+	sendCtx := ctx
+	if !run.sendDeadline.IsZero() {
+		var cancel context.CancelFunc
+		sendCtx, cancel = context.WithDeadline(ctx, run.sendDeadline)
+		defer cancel()
+	}
+
+	sentMessageIds := []string{}
+	for next := 0; room.hasNext(next); {
+		batch, err := run.load.nextBatch(sendCtx, room, next)
+		if err != nil {
+			return sentMessageIds, fmt.Errorf("stopped after %d messages: %w", len(sentMessageIds), err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		next += len(batch)
+		if run.dedup.ExplicitIds {
+			for i := range batch {
+				batch[i].DeduplicationId = deduplicationId(run.id, chatRoom, batch[i].Sequence)
+			}
+		}
+
+		// The entries accepted before a send failure are in the queue, they are
+		// recorded before the room fails so the tester expects them
+		results, sendErr := sendMessageBatch(sendCtx, run.id, chatRoom, batch)
+
+		createdAt := time.Now().Format("2006-01-02T15:04:05.000Z07:00")
+		chatMessages := make([]model.ChatMessage, 0, len(batch))
+		for _, message := range batch {
+			result, ok := results[message.Id]
+			if !ok {
+				continue
+			}
+			chatMessages = append(chatMessages, model.ChatMessage{
+				Room:           chatRoom,
+				MessageId:      *result.MessageId,
+				MessageContent: message.Body,
+				Sender:         message.Sender,
+				Status:         "SENT",
+				CreatedAt:      createdAt,
+				RunId:          run.id,
+				Sequence:       message.Sequence,
+				SequenceNumber: aws.ToString(result.SequenceNumber),
+			})
+			sentMessageIds = append(sentMessageIds, *result.MessageId)
+		}
+
+		if len(chatMessages) > 0 {
+			if err = dynamoTableOptions.AddChatMessages(ctx, chatMessages); err != nil {
+				return sentMessageIds, fmt.Errorf("failed to add chat messages to DynamoDB: %w", err)
+			}
+		}
+
+		for _, message := range chatMessages {
+			slog.InfoContext(lambdalog.With(ctx, lambdalog.KeySQSMessageID, message.MessageId), "Message sent", "sender", message.Sender, "sequence", message.Sequence)
+		}
+
+		if sendErr != nil {
+			return sentMessageIds, fmt.Errorf("failed to send messages: %w", sendErr)
+		}
+
+		if err = sendDuplicates(ctx, sendCtx, run, chatRoom, batch, chatMessages); err != nil {
+			return sentMessageIds, err
 		}
 	}
-	return finalResults
+	return sentMessageIds, nil
 }
 
-func sendMessagesToChatRoom(ctx context.Context, chatRoom string, messageCount int) (map[string][]string, error) {
-	sentMessageIds := make(map[string][]string)
-	for i := 0; i < messageCount; i++ {
-		messageBody := fmt.Sprintf("%d: %s", i, uuid.New().String())
-		sender := "BOT"
-		if i%2 == 0 {
-			sender = "USER"
-		}
-
-		res, err := sqsClient.SendMessage(context.TODO(), &sqs.SendMessageInput{
-			QueueUrl:       aws.String(queueURL),
-			MessageBody:    aws.String(messageBody),
-			MessageGroupId: aws.String(chatRoom),
-			MessageAttributes: map[string]types.MessageAttributeValue{
-				"Sender": {
-					DataType:    aws.String("String"),
-					StringValue: aws.String(sender),
-				},
+// sendMessageBatch sends the messages of a room within a producer span, its
+// trace context is injected in the message attributes to link the consumer
+// spans to it. The queue keeps the order of the entries of a message group.
+// When the last entries fail they are resent, when an entry following a
+// failed one was accepted the room order is broken and an error is returned
+// instead. The results are returned by entry Id, with the error the entries
+// accepted before the failure.
+func sendMessageBatch(ctx context.Context, runId string, chatRoom string, batch []outgoingMessage) (map[string]types.SendMessageBatchResultEntry, error) {
+	ctx, span := tracer.Start(ctx, "SQS.SendMessageBatch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "aws_sqs"),
+			attribute.String("messaging.destination.name", queueURL),
+			attribute.String("chat.room", chatRoom),
+			attribute.Int("messaging.batch.message_count", len(batch)),
+		),
+	)
+	defer span.End()
+
+	entries := make([]types.SendMessageBatchRequestEntry, 0, len(batch))
+	for _, message := range batch {
+		messageAttributes := map[string]types.MessageAttributeValue{
+			"Sender": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(message.Sender),
 			},
-		})
+			"RunId": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(runId),
+			},
+			"Sequence": {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(message.Sequence)),
+			},
+		}
+		tracing.InjectSQS(ctx, messageAttributes)
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to send message: %w", err)
+		entry := types.SendMessageBatchRequestEntry{
+			Id:                aws.String(message.Id),
+			MessageBody:       aws.String(message.Body),
+			MessageGroupId:    aws.String(chatRoom),
+			MessageAttributes: messageAttributes,
 		}
+		if message.DeduplicationId != "" {
+			entry.MessageDeduplicationId = aws.String(message.DeduplicationId)
+		}
+		entries = append(entries, entry)
+	}
 
-		err = dynamoTableOptions.AddChatMessage(ctx, model.ChatMessage{
-			Room:           chatRoom,
-			MessageId:      *res.MessageId,
-			MessageContent: messageBody,
-			Sender:         sender,
-			Status:         "SENT",
-			CreatedAt:      time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
-		})
+	results, err := sendEntries(ctx, entries)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return results, err
+}
+
+func sendEntries(ctx context.Context, entries []types.SendMessageBatchRequestEntry) (map[string]types.SendMessageBatchResultEntry, error) {
+	position := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		position[*entry.Id] = i
+	}
 
+	results := make(map[string]types.SendMessageBatchResultEntry, len(entries))
+	pending := entries
+	delay := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		res, err := sqsClient.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  pending,
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to add chat message to DynamoDB: %w", err)
+			return results, err
 		}
 
-		sentMessageIds[chatRoom] = append(sentMessageIds[chatRoom], *res.MessageId)
+		for _, result := range res.Successful {
+			// A resent entry keeps the result of its first acceptance
+			if _, ok := results[*result.Id]; !ok {
+				results[*result.Id] = result
+			}
+		}
+		if len(res.Failed) == 0 {
+			return results, nil
+		}
+
+		firstFailed := len(entries)
+		for _, failed := range res.Failed {
+			if failed.SenderFault {
+				return results, fmt.Errorf("message %s rejected: %s", *failed.Id, aws.ToString(failed.Message))
+			}
+			firstFailed = min(firstFailed, position[*failed.Id])
+		}
+		// Resending the failed entry would enqueue it behind the accepted ones
+		for _, result := range res.Successful {
+			if position[*result.Id] > firstFailed {
+				return results, fmt.Errorf("message %s accepted after the failed message %s, the room order is broken", *result.Id, *entries[firstFailed].Id)
+			}
+		}
+		if attempt == sendBatchAttempts {
+			return results, fmt.Errorf("%d messages still failing after %d attempts: %s", len(res.Failed), attempt, aws.ToString(res.Failed[0].Message))
+		}
+		slog.WarnContext(ctx, "Resending batch", "failed", len(res.Failed), "attempt", attempt)
+		pending = entries[firstFailed:]
+
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
 	}
-	return sentMessageIds, nil
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultRoomTemplate names the generated rooms when no template is given
+const defaultRoomTemplate = "room-{i}"
+
+// chatRooms returns the explicit rooms followed by the RoomCount generated
+// ones, without duplicates. In the template {i} is replaced by the room
+// number from 1, zero padded to the width of RoomCount, and {run} by the run ID.
+func chatRooms(event SenderEvent, runId string) ([]string, error) {
+	if event.RoomCount < 0 {
+		return nil, fmt.Errorf("invalid input: RoomCount must not be negative")
+	}
+	template := event.RoomTemplate
+	if template == "" {
+		template = defaultRoomTemplate
+	}
+	if event.RoomCount > 1 && !strings.Contains(template, "{i}") {
+		return nil, fmt.Errorf("invalid input: RoomTemplate must contain {i} to generate several rooms")
+	}
+
+	rooms := make([]string, 0, len(event.ChatRoomIds)+event.RoomCount)
+	seen := make(map[string]bool, cap(rooms))
+	add := func(room string) {
+		if !seen[room] {
+			seen[room] = true
+			rooms = append(rooms, room)
+		}
+	}
+
+	for _, room := range event.ChatRoomIds {
+		add(room)
+	}
+	width := len(strconv.Itoa(event.RoomCount))
+	for i := 1; i <= event.RoomCount; i++ {
+		add(strings.NewReplacer(
+			"{i}", fmt.Sprintf("%0*d", width, i),
+			"{run}", runId,
+		).Replace(template))
+	}
+
+	if len(rooms) == 0 {
+		return nil, fmt.Errorf("invalid input: ChatRoomIds or RoomCount is required")
+	}
+	return rooms, nil
+}
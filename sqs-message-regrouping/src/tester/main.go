@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
 	"os"
-	"reflect"
-	"sort"
 
+	lambdalog "aws-labs-lambdalog"
 	model "sqs-message-regrouping-model"
+	tracing "sqs-message-regrouping-tracing"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -16,12 +18,17 @@ import (
 var dynamoTable *dynamodb.Client
 var dynamoTableName = os.Getenv("DYNAMODB_TABLE")
 var dynamoTableOptions *model.TableOptions
+var flushTraces func(context.Context)
 
 func init() {
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		panic(err)
 	}
+	flushTraces, err = tracing.Init(context.TODO(), "sqs-message-regrouping-tester")
+	if err != nil {
+		panic(err)
+	}
 	dynamoTable = dynamodb.NewFromConfig(cfg)
 	dynamoTableOptions = &model.TableOptions{
 		DynamoDbClient: dynamoTable,
@@ -29,47 +36,98 @@ func init() {
 	}
 }
 
+// SenderEvent selects the rooms to verify: a single room, a list of rooms or
+// with LastRun all the rooms of the most recent sender run. With RunId only
+// the messages of that sender run are verified, all its rooms by default.
 type SenderEvent struct {
-	ChatRoomId string `json:"ChatRoomId"`
+	RunId       string   `json:"RunId"`
+	ChatRoomId  string   `json:"ChatRoomId"`
+	ChatRoomIds []string `json:"ChatRoomIds"`
+	LastRun     bool     `json:"LastRun"`
 }
 
-type TestResult struct {
-	SentMessagesIds        []string `json:"SentMessagesIds"`
-	ReceivedMessagesIds    []string `json:"ReceivedMessagesIds"`
-	AreSentAndReceivedSame bool     `json:"AreSentAndReceivedSame"`
-}
+func HandleRequest(ctx context.Context, event SenderEvent) (*RunReport, error) {
+	defer flushTraces(ctx)
 
-func HandleRequest(ctx context.Context, event SenderEvent) (*TestResult, error) {
-	roomMessages, err := dynamoTableOptions.QueryByRoom(ctx, event.ChatRoomId)
+	if event.RunId != "" {
+		ctx = lambdalog.With(ctx, lambdalog.KeyRunID, event.RunId)
+	}
+
+	runId, byRoom, err := roomMessages(ctx, event)
 	if err != nil {
 		return nil, err
 	}
 
-	var sentMessagesIds []string
-	var receivedMessagesIds []string
+	report := newRunReport(byRoom)
+	report.RunId = runId
+	for _, room := range report.Rooms {
+		slog.InfoContext(lambdalog.With(ctx, lambdalog.KeyChatRoom, room.ChatRoomId), "Verified chat room delivery",
+			"sent", len(room.SentMessagesIds),
+			"received", len(room.ReceivedMessagesIds),
+			"same", room.AreSentAndReceivedSame,
+			"first_out_of_order_position", room.FirstOutOfOrderPosition,
+			"missing", len(room.MissingMessagesIds),
+			"duplicated", len(room.DuplicatedMessagesIds),
+			"latency_p99_ms", room.Latency.P99Ms,
+		)
+	}
+	slog.InfoContext(ctx, "Verified run",
+		"run_id", report.RunId,
+		"passed", report.Passed,
+		"rooms", len(report.Rooms),
+		"failed_rooms", len(report.FailedChatRoomIds),
+		"room_switches", report.Interleaving.RoomSwitches,
+		"max_concurrent_rooms", report.Interleaving.MaxConcurrentRooms,
+		"messages_per_second", report.Throughput.MessagesPerSecond,
+	)
+	return &report, nil
+}
 
-	for _, message := range roomMessages {
-		if message.Status == "SENT" {
-			sentMessagesIds = append(sentMessagesIds, message.MessageId)
-		}
-		if message.Status == "RECEIVED" {
-			receivedMessagesIds = append(receivedMessagesIds, message.MessageId)
+// roomMessages returns the run ID, when known, and the messages to verify by room
+func roomMessages(ctx context.Context, event SenderEvent) (string, map[string][]model.ChatMessage, error) {
+	if event.LastRun {
+		messages, err := dynamoTableOptions.ScanMessages(ctx)
+		if err != nil {
+			return "", nil, err
 		}
+		runId, byRoom := lastRunRooms(messages)
+		return runId, byRoom, nil
 	}
 
-	sort.Slice(roomMessages, func(i, j int) bool {
-		return roomMessages[i].CreatedAt < roomMessages[j].CreatedAt
-	})
+	rooms := event.ChatRoomIds
+	if event.ChatRoomId != "" {
+		rooms = append(rooms, event.ChatRoomId)
+	}
 
-	isSameBetweenSentAndReceived := reflect.DeepEqual(sentMessagesIds, receivedMessagesIds)
-	return &TestResult{
-		SentMessagesIds:        sentMessagesIds,
-		ReceivedMessagesIds:    receivedMessagesIds,
-		AreSentAndReceivedSame: isSameBetweenSentAndReceived,
-	}, nil
+	if event.RunId != "" && len(rooms) == 0 {
+		messages, err := dynamoTableOptions.QueryByRun(ctx, event.RunId, "")
+		if err != nil {
+			return "", nil, err
+		}
+		return event.RunId, groupByRoom(messages), nil
+	}
+	if len(rooms) == 0 {
+		return "", nil, fmt.Errorf("invalid input: RunId, ChatRoomId, ChatRoomIds or LastRun is required")
+	}
 
+	byRoom := make(map[string][]model.ChatMessage, len(rooms))
+	for _, room := range rooms {
+		var messages []model.ChatMessage
+		var err error
+		if event.RunId != "" {
+			messages, err = dynamoTableOptions.QueryByRun(ctx, event.RunId, room)
+		} else {
+			messages, err = dynamoTableOptions.QueryByRoom(ctx, room)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		byRoom[room] = messages
+	}
+	return event.RunId, byRoom, nil
 }
 
 func main() {
+	lambdalog.Setup()
 	lambda.Start(HandleRequest)
 }
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	model "sqs-message-regrouping-model"
+)
+
+const createdAtLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// deduplicationWindow is the interval the FIFO queue drops the messages with
+// an already accepted deduplication ID in
+const deduplicationWindow = 5 * time.Minute
+
+type LatencyStats struct {
+	MinMs int64 `json:"MinMs"`
+	P50Ms int64 `json:"P50Ms"`
+	P99Ms int64 `json:"P99Ms"`
+	MaxMs int64 `json:"MaxMs"`
+}
+
+// RoomReport is the delivery verification of a chat room. The sent messages
+// are ordered by the sender sequence and the received ones by the consumer
+// processing position, timestamps are only used for the messages recorded
+// before the sequences were introduced.
+type RoomReport struct {
+	ChatRoomId             string   `json:"ChatRoomId"`
+	SentMessagesIds        []string `json:"SentMessagesIds"`
+	ReceivedMessagesIds    []string `json:"ReceivedMessagesIds"`
+	AreSentAndReceivedSame bool     `json:"AreSentAndReceivedSame"`
+	// FirstOutOfOrderPosition is the first position of the received sequence
+	// not matching the sent sequence, -1 when the order is kept
+	FirstOutOfOrderPosition int `json:"FirstOutOfOrderPosition"`
+	// FirstQueueOutOfOrderPosition is the same check on the received messages
+	// ordered by FIFO sequence number, the order the queue accepted them in,
+	// -1 when it is kept or the sequence numbers are missing
+	FirstQueueOutOfOrderPosition int      `json:"FirstQueueOutOfOrderPosition"`
+	MissingMessagesIds           []string `json:"MissingMessagesIds"`
+	DuplicatedMessagesIds        []string `json:"DuplicatedMessagesIds"`
+	// UnexpectedMessagesIds were received but never recorded as sent
+	UnexpectedMessagesIds []string         `json:"UnexpectedMessagesIds"`
+	MessageLatenciesMs    map[string]int64 `json:"MessageLatenciesMs"`
+	Latency               LatencyStats     `json:"Latency"`
+	// OrderedBySequence is false when the timestamps ordered a side
+	OrderedBySequence bool `json:"OrderedBySequence"`
+	// DuplicateSends counts the deliberate duplicate sends within the
+	// deduplication window, SuppressedDuplicates the ones dropped by the queue
+	// and LeakedDuplicatesIds are the received messages of the others
+	DuplicateSends       int      `json:"DuplicateSends"`
+	SuppressedDuplicates int      `json:"SuppressedDuplicates"`
+	LeakedDuplicatesIds  []string `json:"LeakedDuplicatesIds"`
+}
+
+// timedMessage is a chat message with its parsed timestamp and sequences
+type timedMessage struct {
+	id                string
+	createdAt         time.Time
+	runId             string
+	sequence          int
+	sequenceNumber    string
+	receiveCount      int
+	processedPosition int
+}
+
+// verifyRoom compares the sent sequence with the received one. Messages
+// received but never sent or received twice do not count as out of order,
+// the expected sequence is the sent one without the missing messages. A
+// received message never sent with the run and sequence of a duplicate send
+// is a duplicate the queue did not drop.
+func verifyRoom(room string, messages []model.ChatMessage) RoomReport {
+	var sent, received, duplicates []timedMessage
+	for _, message := range messages {
+		createdAt, _ := time.Parse(createdAtLayout, message.CreatedAt)
+		timed := timedMessage{
+			id:                message.MessageId,
+			createdAt:         createdAt,
+			runId:             message.RunId,
+			sequence:          message.Sequence,
+			sequenceNumber:    message.SequenceNumber,
+			receiveCount:      message.ReceiveCount,
+			processedPosition: message.ProcessedPosition,
+		}
+		switch message.Status {
+		case "SENT":
+			sent = append(sent, timed)
+		case "RECEIVED":
+			received = append(received, timed)
+		case "DUPLICATE":
+			duplicates = append(duplicates, timed)
+		}
+	}
+	sentBySequence := sortBySequence(sent)
+	receivedBySequence := sortByProcessedPosition(received)
+
+	report := RoomReport{
+		ChatRoomId:                   room,
+		OrderedBySequence:            sentBySequence && receivedBySequence,
+		SentMessagesIds:              ids(sent),
+		ReceivedMessagesIds:          ids(received),
+		FirstOutOfOrderPosition:      -1,
+		FirstQueueOutOfOrderPosition: -1,
+		MissingMessagesIds:           []string{},
+		DuplicatedMessagesIds:        []string{},
+		UnexpectedMessagesIds:        []string{},
+		MessageLatenciesMs:           map[string]int64{},
+		LeakedDuplicatesIds:          []string{},
+	}
+
+	sentAt := make(map[string]time.Time, len(sent))
+	for _, message := range sent {
+		sentAt[message.id] = message.createdAt
+	}
+
+	// duplicate sends by run and sequence, true when within the window
+	duplicated := make(map[string]bool, len(duplicates))
+	for _, duplicate := range duplicates {
+		original, ok := sentAt[duplicate.id]
+		withinWindow := ok && duplicate.createdAt.Sub(original) < deduplicationWindow
+		duplicated[duplicateKey(duplicate)] = withinWindow
+		if withinWindow {
+			report.DuplicateSends++
+		}
+	}
+
+	// A redelivered message has a single RECEIVED record counting its deliveries
+	receivedAt := make(map[string]time.Time, len(received))
+	for _, message := range received {
+		if message.receiveCount > 1 {
+			report.DuplicatedMessagesIds = append(report.DuplicatedMessagesIds, message.id)
+		}
+		receivedAt[message.id] = message.createdAt
+
+		sentTime, ok := sentAt[message.id]
+		if !ok {
+			withinWindow, isDuplicate := duplicated[duplicateKey(message)]
+			switch {
+			case !isDuplicate:
+				report.UnexpectedMessagesIds = append(report.UnexpectedMessagesIds, message.id)
+			case withinWindow:
+				report.LeakedDuplicatesIds = append(report.LeakedDuplicatesIds, message.id)
+			}
+			continue
+		}
+		report.MessageLatenciesMs[message.id] = message.createdAt.Sub(sentTime).Milliseconds()
+	}
+
+	var expected []string
+	for _, message := range sent {
+		if _, ok := receivedAt[message.id]; ok {
+			expected = append(expected, message.id)
+		} else {
+			report.MissingMessagesIds = append(report.MissingMessagesIds, message.id)
+		}
+	}
+
+	report.FirstOutOfOrderPosition = firstOutOfOrder(received, sentAt, expected)
+	// The queue order is checked apart, a consumer processing a group out of
+	// order keeps the sequence numbers of the queue order
+	queued := append([]timedMessage(nil), received...)
+	if sortBySequenceNumber(queued) {
+		report.FirstQueueOutOfOrderPosition = firstOutOfOrder(queued, sentAt, expected)
+	}
+
+	report.Latency = latencyStats(report.MessageLatenciesMs)
+	report.SuppressedDuplicates = report.DuplicateSends - len(report.LeakedDuplicatesIds)
+	report.AreSentAndReceivedSame = report.FirstOutOfOrderPosition == -1 &&
+		report.FirstQueueOutOfOrderPosition == -1 &&
+		len(report.MissingMessagesIds) == 0 &&
+		len(report.DuplicatedMessagesIds) == 0 &&
+		len(report.UnexpectedMessagesIds) == 0 &&
+		len(report.LeakedDuplicatesIds) == 0
+	return report
+}
+
+// firstOutOfOrder is the first position of the sent messages of received not
+// matching the expected sequence, -1 when the order is kept
+func firstOutOfOrder(received []timedMessage, sentAt map[string]time.Time, expected []string) int {
+	position := 0
+	for _, message := range received {
+		if _, ok := sentAt[message.id]; !ok {
+			continue
+		}
+		if message.id != expected[position] {
+			return position
+		}
+		position++
+	}
+	return -1
+}
+
+func duplicateKey(message timedMessage) string {
+	return fmt.Sprintf("%s/%d", message.runId, message.sequence)
+}
+
+func sortByTime(messages []timedMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].createdAt.Before(messages[j].createdAt)
+	})
+}
+
+// sortBySequence orders the messages by sender sequence, or by time when a
+// message has none or a sequence is repeated, the room spanning several runs
+func sortBySequence(messages []timedMessage) bool {
+	seen := make(map[int]bool, len(messages))
+	for _, message := range messages {
+		if message.sequence == 0 || seen[message.sequence] {
+			sortByTime(messages)
+			return false
+		}
+		seen[message.sequence] = true
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].sequence < messages[j].sequence
+	})
+	return true
+}
+
+// sortByProcessedPosition orders the messages by consumer processing position,
+// or by time when a message has none or a position is repeated, the room
+// spanning several runs
+func sortByProcessedPosition(messages []timedMessage) bool {
+	seen := make(map[int]bool, len(messages))
+	for _, message := range messages {
+		if message.processedPosition == 0 || seen[message.processedPosition] {
+			sortByTime(messages)
+			return false
+		}
+		seen[message.processedPosition] = true
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].processedPosition < messages[j].processedPosition
+	})
+	return true
+}
+
+// sortBySequenceNumber orders the messages by FIFO sequence number, or by time
+// when a message has none
+func sortBySequenceNumber(messages []timedMessage) bool {
+	for _, message := range messages {
+		if message.sequenceNumber == "" {
+			sortByTime(messages)
+			return false
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return lessSequenceNumber(messages[i].sequenceNumber, messages[j].sequenceNumber)
+	})
+	return true
+}
+
+// lessSequenceNumber compares two SQS sequence numbers, decimal strings too
+// large for an int64
+func lessSequenceNumber(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func ids(messages []timedMessage) []string {
+	result := make([]string, 0, len(messages))
+	for _, message := range messages {
+		result = append(result, message.id)
+	}
+	return result
+}
+
+func latencyStats(latencies map[string]int64) LatencyStats {
+	if len(latencies) == 0 {
+		return LatencyStats{}
+	}
+
+	values := make([]int64, 0, len(latencies))
+	for _, latency := range latencies {
+		values = append(values, latency)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	return LatencyStats{
+		MinMs: values[0],
+		P50Ms: percentile(values, 50),
+		P99Ms: percentile(values, 99),
+		MaxMs: values[len(values)-1],
+	}
+}
+
+// percentile uses the nearest rank method on sorted values
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
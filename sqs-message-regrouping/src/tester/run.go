@@ -0,0 +1,198 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	model "sqs-message-regrouping-model"
+)
+
+// lastRunGap separates two sender runs, the messages of a run are sent
+// continuously so a longer pause between two SENT records starts a new run
+const lastRunGap = 30 * time.Second
+
+// RunReport aggregates the verification of the rooms of a sender run
+type RunReport struct {
+	RunId             string            `json:"RunId,omitempty"`
+	Passed            bool              `json:"Passed"`
+	PassedRooms       int               `json:"PassedRooms"`
+	FailedChatRoomIds []string          `json:"FailedChatRoomIds"`
+	Interleaving      InterleavingStats `json:"Interleaving"`
+	Throughput        ThroughputStats   `json:"Throughput"`
+	Rooms             []RoomReport      `json:"Rooms"`
+}
+
+// InterleavingStats shows whether the message groups were processed in
+// parallel: with a sequential processing a room is drained before the next
+// one starts, RoomSwitches is then the number of rooms minus one
+type InterleavingStats struct {
+	// RoomSwitches counts consecutive received messages of different rooms
+	RoomSwitches int `json:"RoomSwitches"`
+	// SwitchRatio is RoomSwitches over the number of consecutive pairs
+	SwitchRatio float64 `json:"SwitchRatio"`
+	// MaxConcurrentRooms is the highest number of rooms between their first
+	// and last received message at the same time
+	MaxConcurrentRooms int `json:"MaxConcurrentRooms"`
+}
+
+type ThroughputStats struct {
+	SentMessages      int     `json:"SentMessages"`
+	ReceivedMessages  int     `json:"ReceivedMessages"`
+	DurationMs        int64   `json:"DurationMs"`
+	MessagesPerSecond float64 `json:"MessagesPerSecond"`
+}
+
+// lastRunRooms returns the ID and the messages by room of the most recent
+// sender run, the run of the last SENT record. Runs sent before run IDs were
+// introduced are the SENT records before the last lastRunGap pause.
+func lastRunRooms(messages []model.ChatMessage) (string, map[string][]model.ChatMessage) {
+	var sent []timedMessage
+	roomOf := make(map[string]string)
+	runOf := make(map[string]string)
+	for _, message := range messages {
+		if message.Status != "SENT" {
+			continue
+		}
+		createdAt, _ := time.Parse(createdAtLayout, message.CreatedAt)
+		sent = append(sent, timedMessage{id: message.MessageId, createdAt: createdAt})
+		roomOf[message.MessageId] = message.Room
+		runOf[message.MessageId] = message.RunId
+	}
+	sortByTime(sent)
+
+	if len(sent) > 0 {
+		if runId := runOf[sent[len(sent)-1].id]; runId != "" {
+			var runMessages []model.ChatMessage
+			for _, message := range messages {
+				if message.RunId == runId {
+					runMessages = append(runMessages, message)
+				}
+			}
+			return runId, groupByRoom(runMessages)
+		}
+	}
+
+	rooms := make(map[string]bool)
+	for i := len(sent) - 1; i >= 0; i-- {
+		if i < len(sent)-1 && sent[i+1].createdAt.Sub(sent[i].createdAt) > lastRunGap {
+			break
+		}
+		rooms[roomOf[sent[i].id]] = true
+	}
+
+	byRoom := make(map[string][]model.ChatMessage, len(rooms))
+	for _, message := range messages {
+		if rooms[message.Room] {
+			byRoom[message.Room] = append(byRoom[message.Room], message)
+		}
+	}
+	return "", byRoom
+}
+
+func groupByRoom(messages []model.ChatMessage) map[string][]model.ChatMessage {
+	byRoom := make(map[string][]model.ChatMessage)
+	for _, message := range messages {
+		byRoom[message.Room] = append(byRoom[message.Room], message)
+	}
+	return byRoom
+}
+
+// newRunReport verifies every room and computes the cross-room statistics
+func newRunReport(byRoom map[string][]model.ChatMessage) RunReport {
+	rooms := make([]string, 0, len(byRoom))
+	for room := range byRoom {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+
+	report := RunReport{
+		Rooms:             make([]RoomReport, 0, len(rooms)),
+		FailedChatRoomIds: []string{},
+	}
+	for _, room := range rooms {
+		roomReport := verifyRoom(room, byRoom[room])
+		report.Rooms = append(report.Rooms, roomReport)
+		if roomReport.AreSentAndReceivedSame {
+			report.PassedRooms++
+		} else {
+			report.FailedChatRoomIds = append(report.FailedChatRoomIds, room)
+		}
+		report.Throughput.SentMessages += len(roomReport.SentMessagesIds)
+		report.Throughput.ReceivedMessages += len(roomReport.ReceivedMessagesIds)
+	}
+	report.Passed = len(rooms) > 0 && len(report.FailedChatRoomIds) == 0
+
+	var received []receivedMessage
+	for room, messages := range byRoom {
+		for _, message := range messages {
+			if message.Status != "RECEIVED" {
+				continue
+			}
+			createdAt, _ := time.Parse(createdAtLayout, message.CreatedAt)
+			received = append(received, receivedMessage{room: room, createdAt: createdAt})
+		}
+	}
+	sort.SliceStable(received, func(i, j int) bool {
+		return received[i].createdAt.Before(received[j].createdAt)
+	})
+
+	report.Interleaving = interleavingStats(received)
+	if len(received) > 1 {
+		duration := received[len(received)-1].createdAt.Sub(received[0].createdAt)
+		report.Throughput.DurationMs = duration.Milliseconds()
+		if duration > 0 {
+			report.Throughput.MessagesPerSecond = float64(len(received)) / duration.Seconds()
+		}
+	}
+	return report
+}
+
+type receivedMessage struct {
+	room      string
+	createdAt time.Time
+}
+
+func interleavingStats(received []receivedMessage) InterleavingStats {
+	var stats InterleavingStats
+	if len(received) < 2 {
+		return stats
+	}
+
+	first := make(map[string]time.Time)
+	last := make(map[string]time.Time)
+	for i, message := range received {
+		if i > 0 && received[i-1].room != message.room {
+			stats.RoomSwitches++
+		}
+		if _, ok := first[message.room]; !ok {
+			first[message.room] = message.createdAt
+		}
+		last[message.room] = message.createdAt
+	}
+	stats.SwitchRatio = float64(stats.RoomSwitches) / float64(len(received)-1)
+
+	// sweep the rooms activity intervals, starts first on equal timestamps
+	type boundary struct {
+		at    time.Time
+		delta int
+	}
+	var boundaries []boundary
+	for room, start := range first {
+		boundaries = append(boundaries, boundary{at: start, delta: 1}, boundary{at: last[room], delta: -1})
+	}
+	sort.Slice(boundaries, func(i, j int) bool {
+		if boundaries[i].at.Equal(boundaries[j].at) {
+			return boundaries[i].delta > boundaries[j].delta
+		}
+		return boundaries[i].at.Before(boundaries[j].at)
+	})
+
+	active := 0
+	for _, b := range boundaries {
+		active += b.delta
+		if active > stats.MaxConcurrentRooms {
+			stats.MaxConcurrentRooms = active
+		}
+	}
+	return stats
+}
@@ -0,0 +1,86 @@
+// Package tracing sets up OpenTelemetry for the lab Lambdas and carries the
+// W3C trace context from the sender to the consumer in SQS message attributes.
+package tracing
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Init installs the global tracer provider exporting spans with OTLP over
+// HTTP. The collector is configured with the standard OTEL_EXPORTER_OTLP_*
+// environment variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT=http://localhost:4318
+// for a local collector. Tracing is disabled when no endpoint is set.
+// The returned flush must run before the end of each invocation, the
+// execution environment is frozen between invocations.
+func Init(ctx context.Context, serviceName string) (func(context.Context), error) {
+	otel.SetTextMapPropagator(propagator)
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) {}, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) {
+		if err := provider.ForceFlush(ctx); err != nil {
+			otel.Handle(err)
+		}
+	}, nil
+}
+
+// InjectSQS writes the trace context of ctx into the message attributes.
+// It takes one of the 10 attributes SQS allows per message (two with tracestate).
+func InjectSQS(ctx context.Context, attributes map[string]types.MessageAttributeValue) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	for key, value := range carrier {
+		attributes[key] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+}
+
+// ExtractSQS returns ctx with the remote trace context read from the
+// attributes of a received message
+func ExtractSQS(ctx context.Context, attributes map[string]events.SQSMessageAttribute) context.Context {
+	carrier := propagation.MapCarrier{}
+	for _, key := range propagator.Fields() {
+		if attribute, ok := attributes[key]; ok && attribute.StringValue != nil {
+			carrier[key] = *attribute.StringValue
+		}
+	}
+	return propagator.Extract(ctx, carrier)
+}
@@ -19,9 +19,21 @@ resource "aws_dynamodb_table" "table" {
     type = "S"
   }
 
+  attribute {
+    name = "RunId"
+    type = "S"
+  }
+
   global_secondary_index {
     name            = "RoomIndex"
     hash_key        = "Room"
     projection_type = "ALL"
   }
+
+  global_secondary_index {
+    name            = "RunIndex"
+    hash_key        = "RunId"
+    range_key       = "Room"
+    projection_type = "ALL"
+  }
 }
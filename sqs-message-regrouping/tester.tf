@@ -1,13 +1,20 @@
 resource "null_resource" "go_tester_lambda_build" {
   triggers = {
-    source_code = filemd5("${path.module}/src/tester/main.go")
+    source_code = sha1(join("", concat(
+      [for f in sort(fileset("${path.module}/src/tester", "*.go")) : filemd5("${path.module}/src/tester/${f}")],
+      [
+        filemd5("${path.module}/src/model/chat_message.go"),
+        filemd5("${path.module}/src/tracing/tracing.go"),
+        filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+      ],
+    )))
   }
 
   provisioner "local-exec" {
     command = <<EOT
       cd ${path.module}/src/tester
       go mod tidy
-      GOOS=linux GOARCH=amd64 go build -o ../../dist/tester/bootstrap main.go
+      GOOS=linux GOARCH=amd64 go build -o ../../dist/tester/bootstrap .
       cd ../../dist/tester
       zip bootstrap.zip bootstrap
     EOT
@@ -39,7 +46,7 @@ resource "aws_iam_role_policy" "read_table_policy_tester" {
     Version = "2012-10-17"
     Statement = [
       {
-        Action   = "dynamodb:Query",
+        Action   = ["dynamodb:Query", "dynamodb:Scan"],
         Effect   = "Allow",
         Resource = "${aws_dynamodb_table.table.arn}"
       },
@@ -47,6 +54,11 @@ resource "aws_iam_role_policy" "read_table_policy_tester" {
         Action   = "dynamodb:Query",
         Effect   = "Allow",
         Resource = "${aws_dynamodb_table.table.arn}/index/RoomIndex"
+      },
+      {
+        Action   = "dynamodb:Query",
+        Effect   = "Allow",
+        Resource = "${aws_dynamodb_table.table.arn}/index/RunIndex"
       }
     ]
   })
@@ -67,7 +79,8 @@ resource "aws_lambda_function" "tester_lambda" {
 
   environment {
     variables = {
-      DYNAMODB_TABLE = aws_dynamodb_table.table.name
+      DYNAMODB_TABLE              = aws_dynamodb_table.table.name
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
     }
   }
   depends_on = [null_resource.go_tester_lambda_build, aws_iam_role.tester_lambda_role]
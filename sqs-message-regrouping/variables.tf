@@ -9,3 +9,21 @@ variable "resource_prefix" {
   description = "Prefix for all created resources"
   default     = "sqs-message-regrouping"
 }
+
+variable "otel_exporter_otlp_endpoint" {
+  type        = string
+  description = "OTLP/HTTP endpoint of the OpenTelemetry collector receiving the traces, e.g. http://localhost:4318 with the ADOT Lambda layer, tracing is disabled when empty"
+  default     = ""
+}
+
+variable "sender_timeout" {
+  type        = number
+  description = "Timeout in seconds of the sender Lambda, to raise for the duration based load profiles"
+  default     = 30
+}
+
+variable "sender_workers" {
+  type        = number
+  description = "Number of rooms the sender Lambda sends to at the same time"
+  default     = 10
+}
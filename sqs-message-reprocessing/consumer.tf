@@ -1,6 +1,9 @@
 resource "null_resource" "go_consumer_lambda_build" {
   triggers = {
-    source_code = filemd5("${path.module}/src/consumer/main.go")
+    source_code = sha1(join("", [
+      filemd5("${path.module}/src/consumer/main.go"),
+      filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+    ]))
   }
 
   provisioner "local-exec" {
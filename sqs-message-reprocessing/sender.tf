@@ -1,6 +1,9 @@
 resource "null_resource" "go_sender_lambda_build" {
   triggers = {
-    source_code = filemd5("${path.module}/src/sender/main.go")
+    source_code = sha1(join("", [
+      filemd5("${path.module}/src/sender/main.go"),
+      filemd5("${path.module}/../shared/lambdalog/lambdalog.go"),
+    ]))
   }
 
   provisioner "local-exec" {
@@ -2,7 +2,10 @@ module sqs-message-reprocessing-poc
 
 go 1.23
 
+replace aws-labs-lambdalog => ../../../shared/lambdalog
+
 require (
+	aws-labs-lambdalog v0.0.0-00010101000000-000000000000
 	github.com/aws/aws-lambda-go v1.48.0
 	github.com/aws/aws-sdk-go-v2 v1.36.3
 	github.com/aws/aws-sdk-go-v2/config v1.29.14
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
+	lambdalog "aws-labs-lambdalog"
+
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 
@@ -16,6 +18,7 @@ import (
 )
 
 func main() {
+	lambdalog.Setup()
 	lambda.Start(handleMessage)
 }
 
@@ -23,7 +26,7 @@ func handleMessage(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEve
 	mode := os.Getenv("MODE")
 	var batchItemFailures = make([]events.SQSBatchItemFailure, 0)
 
-	printMessageIDs(sqsEvent, mode)
+	printMessageIDs(ctx, sqsEvent, mode)
 	// Simulate processing delay
 	time.Sleep(2 * time.Second)
 
@@ -33,7 +36,7 @@ func handleMessage(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEve
 		for _, record := range sqsEvent.Records {
 			errorMessages = append(errorMessages, record.MessageId)
 		}
-		return events.SQSEventResponse{}, fmt.Errorf("DEBUG (THROWING) %v", errorMessages)
+		return events.SQSEventResponse{}, fmt.Errorf("simulated failure of messages %v", errorMessages)
 	}
 	// https://docs.aws.amazon.com/lambda/latest/dg/services-sqs-errorhandling.html#services-sqs-batchfailurereporting
 	if mode == "PARTIAL_FAILURE" {
@@ -50,7 +53,7 @@ func handleMessage(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEve
 		// Delete the message(s) from the queue after 3 attempts by marking as successfully processed
 		for _, record := range sqsEvent.Records {
 			if record.Attributes["ApproximateReceiveCount"] == "3" {
-				log.Printf("DEBUG (DROPPED after 3 attempts) %s", record.MessageId)
+				slog.InfoContext(lambdalog.With(ctx, lambdalog.KeySQSMessageID, record.MessageId), "Dropped after 3 attempts")
 				continue
 			}
 			batchItemFailures = append(batchItemFailures, events.SQSBatchItemFailure{
@@ -72,7 +75,7 @@ func handleMessage(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEve
 		queueURL := os.Getenv("QUEUE_URL")
 		cfg, err := config.LoadDefaultConfig(ctx)
 		if err != nil {
-			log.Printf("unable to load SDK config, %v", err)
+			slog.ErrorContext(ctx, "Unable to load SDK config", "error", err)
 		}
 		sqsClient := sqs.NewFromConfig(cfg)
 
@@ -86,30 +89,30 @@ func handleMessage(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEve
 				VisibilityTimeout: 0,
 			})
 			if err != nil {
-				log.Printf("unable to change message visibility, %v", err)
+				slog.ErrorContext(lambdalog.With(ctx, lambdalog.KeySQSMessageID, record.MessageId), "Unable to change message visibility", "error", err)
 			}
 		}
 	}
 
-	printBatchItemFailures(batchItemFailures)
+	printBatchItemFailures(ctx, batchItemFailures)
 
 	return events.SQSEventResponse{
 		BatchItemFailures: batchItemFailures,
 	}, nil
 }
 
-func printMessageIDs(sqsEvent events.SQSEvent, mode string) {
+func printMessageIDs(ctx context.Context, sqsEvent events.SQSEvent, mode string) {
 	var messageIDs []string
 	for _, record := range sqsEvent.Records {
 		messageIDs = append(messageIDs, record.MessageId)
 	}
-	log.Printf("DEBUG (RECEIVED) %s %v", mode, messageIDs)
+	slog.InfoContext(ctx, "Received", "mode", mode, "message_ids", messageIDs)
 }
 
-func printBatchItemFailures(batchItemFailures []events.SQSBatchItemFailure) {
+func printBatchItemFailures(ctx context.Context, batchItemFailures []events.SQSBatchItemFailure) {
 	var failedItems []string
 	for _, failure := range batchItemFailures {
 		failedItems = append(failedItems, failure.ItemIdentifier)
 	}
-	log.Printf("DEBUG (FAILED) %v", failedItems)
+	slog.InfoContext(ctx, "Failed", "message_ids", failedItems)
 }
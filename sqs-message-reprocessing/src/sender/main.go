@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
+	lambdalog "aws-labs-lambdalog"
+
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -39,9 +42,9 @@ func handler(ctx context.Context, event Event) (string, error) {
 		})
 
 		if err != nil {
-			fmt.Printf("failed to send message to SQS: %v", err)
+			slog.ErrorContext(ctx, "Failed to send message to SQS", "error", err)
 		} else {
-			fmt.Printf("Message sent to SQS with ID: %s\n", *res.MessageId)
+			slog.InfoContext(lambdalog.With(ctx, lambdalog.KeySQSMessageID, *res.MessageId), "Message sent to SQS")
 		}
 
 	}
@@ -49,5 +52,6 @@ func handler(ctx context.Context, event Event) (string, error) {
 }
 
 func main() {
+	lambdalog.Setup()
 	lambda.Start(handler)
 }